@@ -0,0 +1,778 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+
+	"github.com/spf13/pflag"
+)
+
+// callNode is a function in a call graph.
+type callNode struct {
+	// Key identifies the function. It is the location of the
+	// function in the standard LSP call hierarchy, and its detailed
+	// name for cquery.
+	Key string
+
+	// Name is the plain name of the function.
+	Name string
+
+	// File and Line are the reported path and 1-based line of the
+	// function. cquery doesn't say where the functions other than
+	// the root are, so they are empty until locate finds them.
+	File string
+	Line int
+
+	// item is the call hierarchy item of the function, or nil for
+	// cquery.
+	item *lsp.CallHierarchyItem
+
+	// site is a call that cquery reported for a function whose
+	// location is unknown. If inSite is true, the call is made by
+	// the function, and otherwise it is a call to the function.
+	site   *lsp.Location
+	inSite bool
+}
+
+// callEdge is a call from Caller to Callee. Lines holds the 1-based
+// lines of the call sites in File, which is the file of the caller.
+type callEdge struct {
+	Caller *callNode
+	Callee *callNode
+	File   string
+	Lines  []int
+}
+
+// edgeKey identifies an edge by the keys of its caller and callee.
+type edgeKey struct {
+	Caller string
+	Callee string
+}
+
+// callGraph is part of the call graph of a program, which is built by
+// walking the call hierarchy of a language server from some functions.
+// cquery has its own call hierarchy extension, which returns a whole
+// tree at once, and other servers use the standard LSP call hierarchy,
+// which is walked one function at a time.
+type callGraph struct {
+	c     *client
+	paths *pathMapper
+
+	nodes map[string]*callNode
+	edges map[edgeKey]*callEdge
+
+	// Nodes and Edges hold the nodes and edges in the order that
+	// they were found.
+	Nodes []*callNode
+	Edges []*callEdge
+
+	// callees and callers hold the edges from and to each node.
+	callees map[string][]*callEdge
+	callers map[string][]*callEdge
+
+	// expanded holds the nodes whose callers or callees have been
+	// added, by direction.
+	expanded map[bool]map[string]bool
+}
+
+func newCallGraph(c *client, paths *pathMapper) *callGraph {
+	return &callGraph{
+		c:        c,
+		paths:    paths,
+		nodes:    map[string]*callNode{},
+		edges:    map[edgeKey]*callEdge{},
+		callees:  map[string][]*callEdge{},
+		callers:  map[string][]*callEdge{},
+		expanded: map[bool]map[string]bool{true: {}, false: {}},
+	}
+}
+
+// node returns the node for key, adding it if it is new. If the node
+// exists, its location is filled in if it was unknown.
+func (g *callGraph) node(key string, name string, file string, line int) *callNode {
+	if n, ok := g.nodes[key]; ok {
+		if n.File == "" {
+			n.File, n.Line = file, line
+		}
+
+		return n
+	}
+
+	n := &callNode{
+		Key:  key,
+		Name: name,
+		File: file,
+		Line: line,
+	}
+
+	g.nodes[key] = n
+	g.Nodes = append(g.Nodes, n)
+
+	return n
+}
+
+// itemNode returns the node for a call hierarchy item.
+func (g *callGraph) itemNode(item lsp.CallHierarchyItem) *callNode {
+	n := g.node(itemKey(&item), functionName(item.Name),
+		g.paths.path(item.URI), item.SelectionRange.Start.Line+1)
+
+	if n.item == nil {
+		n.item = &item
+	}
+
+	return n
+}
+
+// addCall records a call from caller to callee at the given line of
+// the caller's file.
+func (g *callGraph) addCall(caller *callNode, callee *callNode, file string, line int) {
+	k := edgeKey{Caller: caller.Key, Callee: callee.Key}
+
+	e, ok := g.edges[k]
+	if !ok {
+		e = &callEdge{Caller: caller, Callee: callee, File: file}
+
+		g.edges[k] = e
+		g.Edges = append(g.Edges, e)
+		g.callees[caller.Key] = append(g.callees[caller.Key], e)
+		g.callers[callee.Key] = append(g.callers[callee.Key], e)
+	}
+
+	for _, l := range e.Lines {
+		if l == line {
+			return
+		}
+	}
+
+	e.Lines = append(e.Lines, line)
+}
+
+// Callees returns the calls made by n that are in the graph.
+func (g *callGraph) Callees(n *callNode) []*callEdge {
+	return g.callees[n.Key]
+}
+
+// Callers returns the calls to n that are in the graph.
+func (g *callGraph) Callers(n *callNode) []*callEdge {
+	return g.callers[n.Key]
+}
+
+// neighbors returns the callers or callees of n that are in the graph.
+func (g *callGraph) neighbors(n *callNode, callers bool) []*callNode {
+	var nodes []*callNode
+
+	if callers {
+		for _, e := range g.Callers(n) {
+			nodes = append(nodes, e.Caller)
+		}
+	} else {
+		for _, e := range g.Callees(n) {
+			nodes = append(nodes, e.Callee)
+		}
+	}
+
+	return nodes
+}
+
+// expand adds the callers or callees of n, and returns the nodes that
+// they lead to. n must have a call hierarchy item.
+func (g *callGraph) expand(n *callNode, callers bool) ([]*callNode, error) {
+	var next []*callNode
+
+	if callers {
+		calls, err := lsp.CallHierarchyIncomingCalls(g.c.srv, *n.item)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range calls {
+			from := g.itemNode(c.From)
+
+			ranges := c.FromRanges
+			if len(ranges) == 0 {
+				ranges = []lsp.Range{c.From.SelectionRange}
+			}
+
+			for _, rng := range ranges {
+				g.addCall(from, n, from.File, rng.Start.Line+1)
+			}
+
+			next = append(next, from)
+		}
+
+		return next, nil
+	}
+
+	calls, err := lsp.CallHierarchyOutgoingCalls(g.c.srv, *n.item)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range calls {
+		to := g.itemNode(c.To)
+
+		for _, rng := range c.FromRanges {
+			g.addCall(n, to, n.File, rng.Start.Line+1)
+		}
+
+		next = append(next, to)
+	}
+
+	return next, nil
+}
+
+// addTree adds a cquery call hierarchy to the graph, and returns the
+// node for its root. The location of each child is its call site.
+func (g *callGraph) addTree(calls *cquery.CallHierarchy, callers bool, file string, line int) *callNode {
+	root := g.node(calls.Name, functionName(calls.Name), file, line)
+
+	var add func(parent *callNode, calls *cquery.CallHierarchy)
+
+	add = func(parent *callNode, calls *cquery.CallHierarchy) {
+		for i := range calls.Children {
+			c := &calls.Children[i]
+			child := g.node(c.Name, functionName(c.Name), "", 0)
+			site := g.paths.path(c.Location.URI)
+
+			if child.File == "" && child.site == nil {
+				child.site = &c.Location
+				child.inSite = callers
+			}
+
+			// NOTE: We convert LSP 0-based lines back to Vim 1-based lines.
+			if callers {
+				g.addCall(child, parent, site, c.Location.Range.Start.Line+1)
+			} else {
+				g.addCall(parent, child, site, c.Location.Range.Start.Line+1)
+			}
+
+			add(child, c)
+		}
+	}
+
+	add(root, calls)
+
+	return root
+}
+
+// locate fills in the locations of the functions that cquery didn't
+// say where they are. A caller is the function that contains one of its
+// call sites, and a callee is the definition of the function that one
+// of its call sites calls. Functions that can't be found keep an empty
+// location.
+func (g *callGraph) locate() error {
+	s := g.c.srv
+	enc := s.PositionEncoding()
+
+	// syms holds the symbols in each file, by path.
+	syms := map[string][]lsp.SymbolInformation{}
+
+	for _, n := range g.Nodes {
+		if n.File != "" || n.site == nil {
+			continue
+		}
+
+		path, err := lsp.URIToPath(n.site.URI)
+		if err != nil {
+			continue
+		}
+
+		// cquery can crash unless the document is open.
+		doc, err := g.c.docs.Open(path)
+		if err != nil {
+			return err
+		}
+
+		pos := n.site.Range.Start
+
+		if !n.inSite {
+			defs, err := lsp.TextDocumentDefinition(s, path, pos.Line, pos.Character)
+			if err != nil {
+				return err
+			}
+
+			// NOTE: We convert LSP 0-based lines back to Vim 1-based lines.
+			if len(defs) > 0 {
+				n.File = g.paths.path(defs[0].URI)
+				n.Line = defs[0].Range.Start.Line + 1
+			}
+
+			continue
+		}
+
+		fileSyms, ok := syms[path]
+		if !ok {
+			if fileSyms, err = lsp.TextDocumentDocumentSymbol(s, path); err != nil {
+				return err
+			}
+
+			syms[path] = fileSyms
+		}
+
+		if fns := enclosingFunctions(fileSyms, []int{pos.Line + 1}); len(fns) > 0 {
+			line, _ := namePosition(doc, fns[0].sym, enc)
+
+			n.File = g.paths.path(lsp.FileToURI(path))
+			n.Line = line + 1
+		}
+	}
+
+	return nil
+}
+
+// walk adds the callers or callees of the function at the given
+// position to the graph, to the given depth, and returns the nodes
+// for the function. The column is in the server's position encoding.
+func (g *callGraph) walk(file string, line int, col int, callers bool, depth int) ([]*callNode, error) {
+	s := g.c.srv
+
+	if g.c.backend == "cquery" {
+		hierarchy := cquery.CalleeHierarchy
+		if callers {
+			hierarchy = cquery.CallerHierarchy
+		}
+
+		calls, err := hierarchy(s, file, line, col, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		root := g.addTree(calls, callers, g.paths.path(lsp.FileToURI(file)), line+1)
+		return []*callNode{root}, nil
+	}
+
+	items, err := lsp.TextDocumentPrepareCallHierarchy(s, file, line, col)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*callNode
+
+	for _, item := range items {
+		roots = append(roots, g.itemNode(item))
+	}
+
+	return roots, g.walkNodes(roots, callers, depth)
+}
+
+// walkNodes adds the callers or callees of the given nodes to the
+// graph, breadth first, to the given depth. Each node is only expanded
+// once in each direction, so recursion doesn't loop.
+func (g *callGraph) walkNodes(nodes []*callNode, callers bool, depth int) error {
+	expanded := g.expanded[callers]
+
+	for level := 0; level < depth && len(nodes) > 0; level++ {
+		var next []*callNode
+
+		// queued holds the nodes in next, since several nodes
+		// can lead to the same neighbor.
+		queued := map[string]bool{}
+
+		add := func(found []*callNode) {
+			for _, n := range found {
+				if !queued[n.Key] {
+					queued[n.Key] = true
+					next = append(next, n)
+				}
+			}
+		}
+
+		for _, n := range nodes {
+			if n.item == nil {
+				continue
+			}
+
+			// A node that an earlier walk expanded still leads
+			// to its neighbors.
+			if expanded[n.Key] {
+				add(g.neighbors(n, callers))
+				continue
+			}
+
+			expanded[n.Key] = true
+
+			found, err := g.expand(n, callers)
+			if err != nil {
+				return err
+			}
+
+			add(found)
+		}
+
+		nodes = next
+	}
+
+	return nil
+}
+
+// flatten returns the calls to or from the roots as results, depth
+// first, to the given depth, with the level of each result in levels.
+// Each call site is a result, with the caller or the callee as the
+// Symbol. Each function is only expanded the first time it is found,
+// so recursion doesn't repeat. Later calls to it are reported, but not
+// descended into.
+func (g *callGraph) flatten(roots []*callNode, callers bool, depth int) ([]cscope.Result, []int) {
+	var results []cscope.Result
+	var levels []int
+
+	expanded := map[string]bool{}
+	for _, n := range roots {
+		expanded[n.Key] = true
+	}
+
+	var visit func(n *callNode, level int)
+
+	visit = func(n *callNode, level int) {
+		edges := g.Callees(n)
+		if callers {
+			edges = g.Callers(n)
+		}
+
+		for _, e := range edges {
+			next := e.Callee
+			if callers {
+				next = e.Caller
+			}
+
+			for _, l := range e.Lines {
+				results = append(results, cscope.Result{
+					File:   e.File,
+					Line:   l,
+					Symbol: next.Name,
+					Text:   "-",
+				})
+
+				levels = append(levels, level)
+			}
+
+			if level < depth && !expanded[next.Key] {
+				expanded[next.Key] = true
+				visit(next, level+1)
+			}
+		}
+	}
+
+	for _, n := range roots {
+		visit(n, 1)
+	}
+
+	return results, levels
+}
+
+// graphNode is a node in the JSON form of a call graph.
+type graphNode struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	Root bool   `json:"root,omitempty"`
+}
+
+// graphSite is a call site in the JSON form of a call graph.
+type graphSite struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// graphEdge is an edge in the JSON form of a call graph.
+type graphEdge struct {
+	Caller int         `json:"caller"`
+	Callee int         `json:"callee"`
+	Sites  []graphSite `json:"sites"`
+}
+
+// graphJSON is the JSON form of a call graph.
+type graphJSON struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// exportGraphs numbers the nodes of the graphs, omitting the nodes in
+// files that the filter excludes, unless they are roots, and the calls
+// to and from them. The filter may be nil.
+func exportGraphs(graphs []*callGraph, roots map[*callNode]bool, filter *pathFilter, wd string) *graphJSON {
+	out := &graphJSON{
+		Nodes: []graphNode{},
+		Edges: []graphEdge{},
+	}
+
+	for _, g := range graphs {
+		ids := map[*callNode]int{}
+
+		for _, n := range g.Nodes {
+			if filter != nil && !roots[n] && !filter.selectedName(n.File, wd) {
+				continue
+			}
+
+			ids[n] = len(out.Nodes)
+			out.Nodes = append(out.Nodes, graphNode{
+				ID:   len(out.Nodes),
+				Name: n.Name,
+				File: n.File,
+				Line: n.Line,
+				Root: roots[n],
+			})
+		}
+
+		for _, e := range g.Edges {
+			caller, ok := ids[e.Caller]
+			if !ok {
+				continue
+			}
+
+			callee, ok := ids[e.Callee]
+			if !ok {
+				continue
+			}
+
+			edge := graphEdge{Caller: caller, Callee: callee}
+			for _, l := range e.Lines {
+				edge.Sites = append(edge.Sites, graphSite{File: e.File, Line: l})
+			}
+
+			out.Edges = append(out.Edges, edge)
+		}
+	}
+
+	return out
+}
+
+// writeDOT writes a call graph in the Graphviz DOT language. Each node
+// is labeled with its function name and location, and each edge with
+// its call sites.
+func writeDOT(w io.Writer, graph *graphJSON) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintf(b, "digraph callgraph {\n")
+	fmt.Fprintf(b, "\tnode [shape=box];\n")
+
+	for _, n := range graph.Nodes {
+		label := n.Name
+		if n.File != "" {
+			label = fmt.Sprintf("%s\n%s:%d", n.Name, n.File, n.Line)
+		}
+
+		attrs := fmt.Sprintf("label=%s", strconv.Quote(label))
+		if n.Root {
+			attrs += ", style=bold"
+		}
+
+		fmt.Fprintf(b, "\tn%d [%s];\n", n.ID, attrs)
+	}
+
+	for _, e := range graph.Edges {
+		sites := make([]string, 0, len(e.Sites))
+		for _, s := range e.Sites {
+			sites = append(sites, fmt.Sprintf("%s:%d", s.File, s.Line))
+		}
+
+		fmt.Fprintf(b, "\tn%d -> n%d [label=%s];\n", e.Caller, e.Callee, strconv.Quote(strings.Join(sites, "\n")))
+	}
+
+	fmt.Fprintf(b, "}\n")
+
+	return b.Flush()
+}
+
+// symbolMatches returns true if name is the name of sym, or its name
+// qualified by its container, as in "ns::foo" or "pkg.Foo".
+func symbolMatches(sym *lsp.SymbolInformation, name string) bool {
+	if sym.Name == name {
+		return true
+	}
+
+	if sym.ContainerName == nil {
+		return false
+	}
+
+	c := *sym.ContainerName
+	return c+"::"+sym.Name == name || c+"."+sym.Name == name
+}
+
+// symbolPositions returns the positions of the names of the functions
+// called name, which the workspace symbols of the server of c match.
+// The columns are byte columns, as in a query.
+func symbolPositions(c *client, name string) ([]*queryPosition, error) {
+	syms, err := lsp.WorkspaceSymbol(c.srv, unqualifiedName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []*queryPosition
+
+	seen := map[string]bool{}
+
+	for i := range syms {
+		sym := &syms[i]
+
+		if !isFunctionSymbol(sym.Kind) || !symbolMatches(sym, name) {
+			continue
+		}
+
+		path, err := lsp.URIToPath(sym.Location.URI)
+		if err != nil {
+			continue
+		}
+
+		doc, err := c.docs.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		line, col := namePosition(doc, sym, c.srv.PositionEncoding())
+
+		if k := fmt.Sprintf("%s:%d", path, line); !seen[k] {
+			seen[k] = true
+			positions = append(positions, &queryPosition{File: path, Line: line, Col: col})
+		}
+	}
+
+	return positions, nil
+}
+
+// callGraphCommand implements the "callgraph" subcommand, which writes
+// the call graph around some functions as DOT or JSON.
+func callGraphCommand(args []string) error {
+	flags := pflag.NewFlagSet("callgraph", pflag.ContinueOnError)
+	depth := flags.IntP("depth", "n", 2, "Number of levels of calls to follow from each root")
+	direction := flags.String("direction", "callees", "Follow 'callees', 'callers' or 'both'")
+	format := flags.String("format", "dot", "Write the graph as 'dot' or 'json'")
+	language := flags.String("language", "cpp", "Language of the server that looks up ROOT function names")
+
+	flags.AddFlagSet(sessionFlags)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s callgraph [OPTION...] ROOT...\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nWrites the call graph around the ROOT functions. Each ROOT is a\n")
+		fmt.Fprintf(os.Stderr, "position, \"file:line:col\", or the name of a function, which the\n")
+		fmt.Fprintf(os.Stderr, "server for --language looks up. Functions in excluded files are\n")
+		fmt.Fprintf(os.Stderr, "omitted, unless they are roots.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing root functions")
+	}
+
+	if *depth < 1 {
+		return fmt.Errorf("invalid depth %d", *depth)
+	}
+
+	var dirs []bool
+
+	switch *direction {
+	case "callees":
+		dirs = []bool{false}
+	case "callers":
+		dirs = []bool{true}
+	case "both":
+		dirs = []bool{true, false}
+	default:
+		return fmt.Errorf("invalid direction '%s'", *direction)
+	}
+
+	if *format != "dot" && *format != "json" {
+		return fmt.Errorf("invalid format '%s'", *format)
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.close()
+
+	var positions []*queryPosition
+
+	// A root that isn't a position is a function name.
+	for _, arg := range flags.Args() {
+		if pos, err := parseQueryPattern(arg); err == nil {
+			positions = append(positions, pos)
+			continue
+		}
+
+		c, err := sess.reg.client(*language)
+		if err != nil {
+			return err
+		}
+
+		found, err := symbolPositions(c, arg)
+		if err != nil {
+			return err
+		}
+
+		if len(found) == 0 {
+			return fmt.Errorf("no function named '%s'", arg)
+		}
+
+		positions = append(positions, found...)
+	}
+
+	paths := sess.opts.Paths
+
+	// Roots in different languages have separate graphs.
+	var graphs []*callGraph
+
+	clients := map[*client]*callGraph{}
+	roots := map[*callNode]bool{}
+
+	for _, pos := range positions {
+		c, col, err := openPosition(sess.reg, pos)
+		if err != nil {
+			return err
+		}
+
+		g, ok := clients[c]
+		if !ok {
+			g = newCallGraph(c, paths)
+			clients[c] = g
+			graphs = append(graphs, g)
+		}
+
+		for _, callers := range dirs {
+			nodes, err := g.walk(pos.File, pos.Line, col, callers, *depth)
+			if err != nil {
+				return err
+			}
+
+			if len(nodes) == 0 {
+				return fmt.Errorf("no function at %s", pos.File)
+			}
+
+			for _, n := range nodes {
+				roots[n] = true
+			}
+		}
+	}
+
+	for _, g := range graphs {
+		if err := g.locate(); err != nil {
+			return err
+		}
+	}
+
+	graph := exportGraphs(graphs, roots, sess.opts.Filter, paths.wd)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(graph)
+	}
+
+	return writeDOT(os.Stdout, graph)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+func TestExportGraphs(t *testing.T) {
+	ws := &workspace.Workspace{}
+	ws.Add(&workspace.Root{Dir: "/src"})
+
+	filter, err := newPathFilter(ws, nil, []string{"vendor/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newCallGraph(nil, nil)
+
+	mainFunc := g.node("main", "main", "main.c", 8)
+	twice := g.node("twice", "twice", "main.c", 3)
+	add := g.node("add", "add", "util.c", 3)
+	memcpy := g.node("memcpy", "memcpy", "vendor/memcpy.c", 1)
+	start := g.node("start", "start", "", 0)
+	hook := g.node("hook", "hook", "vendor/hook.c", 2)
+
+	g.addCall(mainFunc, twice, "main.c", 10)
+	g.addCall(mainFunc, add, "main.c", 10)
+	g.addCall(twice, add, "main.c", 5)
+	g.addCall(add, memcpy, "util.c", 4)
+	g.addCall(start, mainFunc, "crt.c", 2)
+	g.addCall(start, mainFunc, "crt.c", 4)
+	g.addCall(hook, add, "vendor/hook.c", 3)
+
+	// Roots in another language are in a separate graph.
+	py := newCallGraph(nil, nil)
+	run := py.node("run", "run", "run.py", 1)
+
+	roots := map[*callNode]bool{mainFunc: true, hook: true, run: true}
+
+	// The excluded memcpy and the calls to it are omitted, but the
+	// excluded hook is a root, and start has no file to exclude.
+	want := &graphJSON{
+		Nodes: []graphNode{
+			{ID: 0, Name: "main", File: "main.c", Line: 8, Root: true},
+			{ID: 1, Name: "twice", File: "main.c", Line: 3},
+			{ID: 2, Name: "add", File: "util.c", Line: 3},
+			{ID: 3, Name: "start"},
+			{ID: 4, Name: "hook", File: "vendor/hook.c", Line: 2, Root: true},
+			{ID: 5, Name: "run", File: "run.py", Line: 1, Root: true},
+		},
+		Edges: []graphEdge{
+			{Caller: 0, Callee: 1, Sites: []graphSite{{File: "main.c", Line: 10}}},
+			{Caller: 0, Callee: 2, Sites: []graphSite{{File: "main.c", Line: 10}}},
+			{Caller: 1, Callee: 2, Sites: []graphSite{{File: "main.c", Line: 5}}},
+			{Caller: 3, Callee: 0, Sites: []graphSite{{File: "crt.c", Line: 2}, {File: "crt.c", Line: 4}}},
+			{Caller: 4, Callee: 2, Sites: []graphSite{{File: "vendor/hook.c", Line: 3}}},
+		},
+	}
+
+	got := exportGraphs([]*callGraph{g, py}, roots, filter, "/src")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Without a filter, every node is exported.
+	if got := exportGraphs([]*callGraph{g, py}, roots, nil, "/src"); len(got.Nodes) != 7 || len(got.Edges) != 6 {
+		t.Errorf("got %d nodes and %d edges, want 7 and 6", len(got.Nodes), len(got.Edges))
+	}
+
+	// An empty graph has empty lists rather than nulls.
+	b, err := json.Marshal(exportGraphs(nil, nil, nil, "/src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != `{"nodes":[],"edges":[]}` {
+		t.Errorf("got %s for an empty graph", b)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	graph := &graphJSON{
+		Nodes: []graphNode{
+			{ID: 0, Name: "main", File: "main.c", Line: 8, Root: true},
+			{ID: 1, Name: "start"},
+		},
+		Edges: []graphEdge{
+			{Caller: 1, Callee: 0, Sites: []graphSite{{File: "crt.c", Line: 2}, {File: "crt.c", Line: 4}}},
+		},
+	}
+
+	want := "digraph callgraph {\n" +
+		"\tnode [shape=box];\n" +
+		"\tn0 [label=\"main\\nmain.c:8\", style=bold];\n" +
+		"\tn1 [label=\"start\"];\n" +
+		"\tn1 -> n0 [label=\"crt.c:2\\ncrt.c:4\"];\n" +
+		"}\n"
+
+	var b bytes.Buffer
+
+	if err := writeDOT(&b, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSymbolPositions(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.WorkspaceSymbol(
+		fakeSymbol(t, "add", "", lsp.SymbolKindFunction, "util.c", 2, 5),
+		fakeSymbol(t, "addr", "", lsp.SymbolKindFunction, "util.c", 2, 5),
+		fakeSymbol(t, "add", "", lsp.SymbolKindVariable, "main.c", 0, 0),
+		fakeSymbol(t, "twice", "ns", lsp.SymbolKindFunction, "main.c", 2, 5),
+	)
+
+	reg := fakeRegistry(t, fake)
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abs := func(file string) string {
+		p, err := filepath.Abs(filepath.Join("testdata/src", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	tests := []struct {
+		name string
+		want []*queryPosition
+	}{
+		{"add", []*queryPosition{{File: abs("util.c"), Line: 2, Col: 4}}},
+		{"twice", []*queryPosition{{File: abs("main.c"), Line: 2, Col: 11}}},
+		{"ns::twice", []*queryPosition{{File: abs("main.c"), Line: 2, Col: 11}}},
+		{"other::twice", nil},
+		{"missing", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := symbolPositions(c, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	call := func(name string, file string, line int, col int, children ...cquery.CallHierarchy) cquery.CallHierarchy {
+		return cquery.CallHierarchy{
+			Name:     name,
+			Location: fakeLocation(t, file, line, col, col+3),
+			Children: children,
+		}
+	}
+
+	// The callers of add are found from the functions that contain
+	// their calls, and the callees of main from the definitions of
+	// the functions that it calls.
+	callers := call("add", "util.c", 2, 4,
+		call("twice", "main.c", 4, 8),
+		call("main", "main.c", 9, 8),
+	)
+
+	callees := call("main", "main.c", 7, 4,
+		call("add", "main.c", 9, 8),
+		call("twice", "main.c", 9, 16),
+		call("exit", "main.c", 9, 30),
+	)
+
+	fake := lsptest.NewServer()
+	fake.CallHierarchy(&callers, &callees)
+	fake.DocumentSymbol(
+		fakeSymbol(t, "twice", "", lsp.SymbolKindFunction, "main.c", 2, 5),
+		fakeSymbol(t, "main", "", lsp.SymbolKindFunction, "main.c", 7, 10),
+	)
+
+	// The definition of each function called on line 9 of main.c,
+	// by column. exit has no definition.
+	defs := map[int]lsp.Location{
+		8:  fakeLocation(t, "util.c", 2, 4, 7),
+		16: fakeLocation(t, "main.c", 2, 11, 16),
+	}
+
+	fake.Handle("textDocument/definition", func(params json.RawMessage) (interface{}, error) {
+		var p lsp.TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		if loc, ok := defs[p.Position.Character]; ok {
+			return []lsp.Location{loc}, nil
+		}
+
+		return []lsp.Location{}, nil
+	})
+
+	reg := fakeRegistry(t, fake)
+	reg.register(&serverConfig{Path: "cquery"}, "c")
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := newPathMapper(reg.ws)
+
+	tests := []struct {
+		name    string
+		file    string
+		line    int
+		callers bool
+		want    []string
+	}{{
+		name:    "callers",
+		file:    "util.c",
+		line:    2,
+		callers: true,
+		want: []string{
+			"add testdata/src/util.c:3",
+			"twice testdata/src/main.c:3",
+			"main testdata/src/main.c:8",
+		},
+	}, {
+		name: "callees",
+		file: "main.c",
+		line: 7,
+		want: []string{
+			"main testdata/src/main.c:8",
+			"add testdata/src/util.c:3",
+			"twice testdata/src/main.c:3",
+			"exit :0",
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newCallGraph(c, paths)
+
+			file, err := filepath.Abs(filepath.Join("testdata/src", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := g.walk(file, tt.line, 4, tt.callers, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := g.locate(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, n := range g.Nodes {
+				got = append(got, fmt.Sprintf("%s %s:%d", n.Name, n.File, n.Line))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+)
+
+// functionName returns the plain name of a function from a detailed
+// name such as "static int ns::foo(int)", which would be "ns::foo".
+// cscope fields can't contain whitespace, so it returns "-" if there
+// is no name.
+func functionName(name string) string {
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i]
+	}
+
+	f := strings.Fields(name)
+	if len(f) == 0 {
+		return "-"
+	}
+
+	if n := strings.TrimLeft(f[len(f)-1], "*&"); n != "" {
+		return n
+	}
+
+	return "-"
+}
+
+// itemKey identifies a call hierarchy item.
+func itemKey(item *lsp.CallHierarchyItem) string {
+	return fmt.Sprintf("%s:%d:%d", item.URI, item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+}
+
+// searchCalls finds the callers or callees of the function at the
+// given position, to the given depth. It walks the call graph from the
+// function, and returns the results in depth-first order, with the
+// level of each result in levels.
+func searchCalls(c *client, paths *pathMapper, file string, line int, col int, callers bool, depth int) ([]cscope.Result, []int, error) {
+	if depth < 1 {
+		depth = 1
+	}
+
+	g := newCallGraph(c, paths)
+
+	roots, err := g.walk(file, line, col, callers, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results, levels := g.flatten(roots, callers, depth)
+	return results, levels, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+)
+
+func TestFunctionName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"add", "add"},
+		{"int add(int a, int b)", "add"},
+		{"static int ns::foo(int)", "ns::foo"},
+		{"char *strdup(const char *)", "strdup"},
+		{"const std::string &name() const", "name"},
+		{"", "-"},
+		{"(anonymous)", "-"},
+		{"**", "-"},
+	}
+
+	for _, tt := range tests {
+		if got := functionName(tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// callItem returns a call hierarchy item for a function in testdata/src
+// whose name is on the given 0-based line.
+func callItem(t *testing.T, name string, file string, line int) lsp.CallHierarchyItem {
+	loc := fakeLocation(t, file, line, 4, 4+len(name))
+
+	return lsp.CallHierarchyItem{
+		Name:           name,
+		Kind:           lsp.SymbolKindFunction,
+		URI:            loc.URI,
+		Range:          loc.Range,
+		SelectionRange: loc.Range,
+	}
+}
+
+// callRanges returns a range on each of the given 0-based lines.
+func callRanges(lines ...int) []lsp.Range {
+	var ranges []lsp.Range
+	for _, l := range lines {
+		ranges = append(ranges, lsp.Range{
+			Start: lsp.Position{Line: l, Character: 8},
+			End:   lsp.Position{Line: l, Character: 11},
+		})
+	}
+	return ranges
+}
+
+// searchFakeCalls searches the callers or callees of add in util.c,
+// using fake as a server with the given backend.
+func searchFakeCalls(t *testing.T, fake *lsptest.Server, backend string, callers bool, depth int) ([]cscope.Result, []int) {
+	t.Helper()
+
+	reg := fakeRegistry(t, fake)
+	reg.register(&serverConfig{Path: backend}, "c")
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := filepath.Abs("testdata/src/util.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, levels, err := searchCalls(c, newPathMapper(reg.ws), file, 2, 4, callers, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return results, levels
+}
+
+func TestSearchCallsIncoming(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.PrepareCallHierarchy(callItem(t, "add", "util.c", 2))
+	fake.IncomingCalls("add", lsp.CallHierarchyIncomingCall{
+		From:       callItem(t, "twice", "main.c", 2),
+		FromRanges: callRanges(4),
+	}, lsp.CallHierarchyIncomingCall{
+		// Each call from the same function is a result.
+		From:       callItem(t, "main", "main.c", 7),
+		FromRanges: callRanges(8, 9),
+	}, lsp.CallHierarchyIncomingCall{
+		// Without call ranges, the caller itself is reported.
+		From: callItem(t, "run", "run.c", 20),
+	})
+
+	want := []cscope.Result{
+		{File: "testdata/src/main.c", Symbol: "twice", Line: 5, Text: "-"},
+		{File: "testdata/src/main.c", Symbol: "main", Line: 9, Text: "-"},
+		{File: "testdata/src/main.c", Symbol: "main", Line: 10, Text: "-"},
+		{File: "testdata/src/run.c", Symbol: "run", Line: 21, Text: "-"},
+	}
+
+	results, levels := searchFakeCalls(t, fake, "clangd", true, 1)
+
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+
+	if !reflect.DeepEqual(levels, []int{1, 1, 1, 1}) {
+		t.Errorf("got levels %v, want all 1", levels)
+	}
+}
+
+func TestSearchCallsOutgoing(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.PrepareCallHierarchy(callItem(t, "add", "util.c", 2))
+	fake.OutgoingCalls("add", lsp.CallHierarchyOutgoingCall{
+		// The call sites are in the caller, not the callee.
+		To:         callItem(t, "log", "log.c", 2),
+		FromRanges: callRanges(3),
+	}, lsp.CallHierarchyOutgoingCall{
+		To:         callItem(t, "check", "check.c", 2),
+		FromRanges: callRanges(3, 4),
+	}, lsp.CallHierarchyOutgoingCall{
+		// A callee without call ranges has no call sites.
+		To: callItem(t, "exit", "exit.c", 1),
+	})
+
+	want := []cscope.Result{
+		{File: "testdata/src/util.c", Symbol: "log", Line: 4, Text: "-"},
+		{File: "testdata/src/util.c", Symbol: "check", Line: 4, Text: "-"},
+		{File: "testdata/src/util.c", Symbol: "check", Line: 5, Text: "-"},
+	}
+
+	results, _ := searchFakeCalls(t, fake, "clangd", false, 1)
+
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+}
+
+func TestSearchCallsCquery(t *testing.T) {
+	call := func(name string, file string, line int, children ...cquery.CallHierarchy) cquery.CallHierarchy {
+		return cquery.CallHierarchy{
+			Name:     name,
+			Location: fakeLocation(t, file, line, 8, 11),
+			Children: children,
+		}
+	}
+
+	// The callers of add. The recursive call from twice to itself is
+	// reported, but twice isn't descended into again, and neither is
+	// add, which is the root. main is expanded under twice, so its
+	// callers aren't repeated where main calls add directly.
+	calls := call("add", "util.c", 2,
+		call("int twice(int x)", "main.c", 4,
+			call("int twice(int x)", "main.c", 5,
+				call("int main(void)", "main.c", 9),
+			),
+			call("int main(void)", "main.c", 9,
+				call("void start(void)", "crt.c", 1),
+			),
+		),
+		call("int main(void)", "main.c", 9,
+			call("void start(void)", "crt.c", 1),
+		),
+		call("add", "util.c", 6,
+			call("int main(void)", "main.c", 9),
+		),
+	)
+
+	fake := lsptest.NewServer()
+	fake.CallHierarchy(&calls, nil)
+
+	wantResults := []cscope.Result{
+		{File: "testdata/src/main.c", Symbol: "twice", Line: 5, Text: "-"},
+		{File: "testdata/src/main.c", Symbol: "twice", Line: 6, Text: "-"},
+		{File: "testdata/src/main.c", Symbol: "main", Line: 10, Text: "-"},
+		{File: "testdata/src/crt.c", Symbol: "start", Line: 2, Text: "-"},
+		{File: "testdata/src/main.c", Symbol: "main", Line: 10, Text: "-"},
+		{File: "testdata/src/util.c", Symbol: "add", Line: 7, Text: "-"},
+	}
+
+	wantLevels := []int{1, 2, 2, 3, 1, 1}
+
+	results, levels := searchFakeCalls(t, fake, "cquery", true, 3)
+
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("got results %+v, want %+v", results, wantResults)
+	}
+
+	if !reflect.DeepEqual(levels, wantLevels) {
+		t.Errorf("got levels %v, want %v", levels, wantLevels)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+
+	"github.com/spf13/pflag"
+)
+
+// vimSearchTypes maps the search names used by vim's ":cscope find"
+// command to cscope search types.
+var vimSearchTypes = map[string]cscope.SearchType{
+	"s": cscope.FindSymbol,
+	"g": cscope.FindDefinition,
+	"d": cscope.FindCallees,
+	"c": cscope.FindCallers,
+	"t": cscope.FindTextString,
+	"e": cscope.FindEgrepPattern,
+	"f": cscope.FindFile,
+	"i": cscope.FindIncludingFiles,
+	"p": cscope.FindCallPath,
+}
+
+// parseClientQuery parses a query of the form "TYPE PATTERN", where
+// TYPE is a vim search name or a cscope search number.
+func parseClientQuery(line string) (cscope.SearchType, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("invalid query '%s'", line)
+	}
+
+	if search, ok := vimSearchTypes[parts[0]]; ok {
+		return search, parts[1], nil
+	}
+
+	for _, search := range vimSearchTypes {
+		if parts[0] == fmt.Sprint(int(search)) || parts[0] == search.String() {
+			return search, parts[1], nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("invalid search type '%s'", parts[0])
+}
+
+// cscopeClient implements the "client" subcommand, which runs a cscope
+// compatible program the same way vim does, and sends it the queries
+// read from stdin.
+func cscopeClient(args []string) error {
+	flags := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	prog := flags.StringP("prog", "p", os.Args[0], "Path to the cscope-compatible program")
+	reffile := flags.StringP("reffile", "f", "cscope.out", "Cross-reference file name to pass to the program")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s client [OPTION...] [-- ARG...]\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nReads queries of the form \"TYPE PATTERN\" from stdin, where TYPE\n")
+		fmt.Fprintf(os.Stderr, "is a vim search name (s, g, d, c, t, e, f, i), \"p\" for a call\n")
+		fmt.Fprintf(os.Stderr, "path, or a number. The query \"reset\" restarts the program.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Start the program with the same arguments that vim uses,
+	// followed by any extra arguments.
+	argv := append([]string{"-dl", "-f", *reffile}, flags.Args()...)
+
+	proc, err := cscope.Start(*prog, argv...)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %s", *prog, err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch line {
+		case "":
+			continue
+		case "reset":
+			if err := proc.Reset(); err != nil {
+				return fmt.Errorf("failed to reset %s: %s", *prog, err)
+			}
+			continue
+		}
+
+		search, pattern, err := parseClientQuery(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
+			continue
+		}
+
+		results, err := proc.Find(search, pattern)
+		if err != nil {
+			return fmt.Errorf("%s query failed: %s", search, err)
+		}
+
+		fmt.Printf("cscope: %d lines\n", len(results))
+		for _, r := range results {
+			fmt.Println(r)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return proc.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/glob"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+// pathFilter selects files by include and exclude glob patterns. A
+// file is selected if it matches any include pattern, or there are no
+// include patterns, and it matches no exclude pattern.
+//
+// Patterns without a "/" are matched against the file name. Other
+// patterns are matched against the path relative to the workspace
+// root that contains the file, the absolute path, and the path that
+// we report to vim.
+type pathFilter struct {
+	ws      *workspace.Workspace
+	include []*glob.Glob
+	exclude []*glob.Glob
+}
+
+func compileGlobs(patterns []string) ([]*glob.Glob, error) {
+	globs := make([]*glob.Glob, 0, len(patterns))
+
+	for _, p := range patterns {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		globs = append(globs, g)
+	}
+
+	return globs, nil
+}
+
+func newPathFilter(ws *workspace.Workspace, include []string, exclude []string) (*pathFilter, error) {
+	var err error
+
+	f := &pathFilter{ws: ws}
+
+	if f.include, err = compileGlobs(include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = compileGlobs(exclude); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// matchAny returns true if any of the globs matches the file at the
+// absolute path abs, which we report to vim as name. If dir is true,
+// the path is a directory, and is matched with a trailing "/" so that
+// patterns like "third_party/**" match the directory itself.
+func (f *pathFilter) matchAny(globs []*glob.Glob, abs string, name string, dir bool) bool {
+	var rel string
+
+	if r := f.ws.Find(abs); r != nil {
+		rel, _ = filepath.Rel(r.Dir, abs)
+	}
+
+	candidates := []string{abs, name}
+	if rel != "" && rel != "." {
+		candidates = append(candidates, rel)
+	}
+
+	for _, g := range globs {
+		if !strings.Contains(g.String(), "/") {
+			if !dir && g.Match(filepath.Base(abs)) {
+				return true
+			}
+			continue
+		}
+
+		for _, c := range candidates {
+			if dir {
+				c += "/"
+			}
+
+			if g.Match(c) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// selected returns true if the file at the absolute path abs, which
+// we report to vim as name, passes the filter.
+func (f *pathFilter) selected(abs string, name string) bool {
+	if len(f.include) > 0 && !f.matchAny(f.include, abs, name, false) {
+		return false
+	}
+
+	return !f.matchAny(f.exclude, abs, name, false)
+}
+
+// prune returns true if everything in the directory at the absolute
+// path dir is excluded, so that a scan can skip it.
+func (f *pathFilter) prune(dir string) bool {
+	return f.matchAny(f.exclude, dir, dir, true)
+}
+
+// selectedName returns true if the file that we report to vim as
+// name passes the filter. A relative name is relative to the working
+// directory wd. A result without a file, such as a function that the
+// server has no location for, is always selected.
+func (f *pathFilter) selectedName(name string, wd string) bool {
+	if name == "" {
+		return true
+	}
+
+	abs := name
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(wd, abs)
+	}
+
+	return f.selected(abs, name)
+}
+
+// filter removes the results that do not pass the filter. Relative
+// result paths are relative to the working directory wd.
+func (f *pathFilter) filter(results []cscope.Result, wd string) []cscope.Result {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return results
+	}
+
+	kept := results[:0]
+
+	for _, r := range results {
+		if f.selectedName(r.File, wd) {
+			kept = append(kept, r)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/diff"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+
+	"github.com/spf13/pflag"
+)
+
+// isFunctionSymbol returns true if a symbol of the given kind is a
+// function.
+func isFunctionSymbol(kind int) bool {
+	switch lsp.SymbolKind(kind) {
+	case lsp.SymbolKindFunction, lsp.SymbolKindMethod, lsp.SymbolKindConstructor:
+		return true
+	default:
+		return false
+	}
+}
+
+// changedFunction is a function that a patch changes.
+type changedFunction struct {
+	sym *lsp.SymbolInformation
+
+	// lines holds the 1-based lines of the function that changed.
+	lines []int
+}
+
+// enclosingFunctions returns the functions that contain the given
+// 1-based lines, in the order of the lines. Each line belongs to the
+// smallest function that contains it, and lines outside any function
+// are ignored.
+func enclosingFunctions(syms []lsp.SymbolInformation, lines []int) []*changedFunction {
+	var changed []*changedFunction
+
+	funcs := map[*lsp.SymbolInformation]*changedFunction{}
+
+	for _, l := range lines {
+		var best *lsp.SymbolInformation
+
+		for i := range syms {
+			sym := &syms[i]
+			rng := sym.Location.Range
+
+			if !isFunctionSymbol(sym.Kind) || l-1 < rng.Start.Line || l-1 > rng.End.Line {
+				continue
+			}
+
+			if best == nil || rng.LineCount() < best.Location.Range.LineCount() {
+				best = sym
+			}
+		}
+
+		if best == nil {
+			continue
+		}
+
+		f, ok := funcs[best]
+		if !ok {
+			f = &changedFunction{sym: best}
+			funcs[best] = f
+			changed = append(changed, f)
+		}
+
+		f.lines = append(f.lines, l)
+	}
+
+	return changed
+}
+
+// isIdentByte returns true if b can be part of an identifier.
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// unqualifiedName returns the last component of a symbol name, without
+// any parameters, so "ns::foo(int)" is "foo", and "(*T).Method" is
+// "Method".
+func unqualifiedName(name string) string {
+	if i := strings.IndexByte(name, '('); i > 0 {
+		name = name[:i]
+	}
+
+	if i := strings.LastIndexAny(name, ":."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+// namePosition returns the 0-based line and byte column of the name of
+// a symbol, which is where servers expect to prepare a call hierarchy.
+// The range of a symbol usually covers its whole definition, so the
+// lines of the range are searched for the unqualified name. If it isn't
+// found, the start of the range is returned, converted from the
+// server's position encoding enc to a byte column.
+func namePosition(doc lineReader, sym *lsp.SymbolInformation, enc lsp.PositionEncoding) (int, int) {
+	name := unqualifiedName(sym.Name)
+	rng := sym.Location.Range
+
+	if name != "" {
+		for l := rng.Start.Line; l <= rng.End.Line; l++ {
+			text := doc.Line(l)
+
+			for off := 0; off < len(text); {
+				i := strings.Index(text[off:], name)
+				if i < 0 {
+					break
+				}
+
+				start, end := off+i, off+i+len(name)
+				if (start == 0 || !isIdentByte(text[start-1])) && (end == len(text) || !isIdentByte(text[end])) {
+					return l, start
+				}
+
+				off = end
+			}
+		}
+	}
+
+	l := rng.Start.Line
+	return l, lsp.CharacterToByte(doc.Line(l), rng.Start.Character, enc)
+}
+
+// impactCall is a call made by an impacted function, in the JSON
+// report.
+type impactCall struct {
+	Callee string `json:"callee"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+}
+
+// impactFunction is a function in the JSON report. Depth is 0 for the
+// functions that changed, and Calls holds the calls that lead to the
+// functions one level closer to the change.
+type impactFunction struct {
+	Name    string       `json:"name"`
+	File    string       `json:"file,omitempty"`
+	Line    int          `json:"line,omitempty"`
+	Depth   int          `json:"depth"`
+	Changed []int        `json:"changed,omitempty"`
+	Calls   []impactCall `json:"calls,omitempty"`
+}
+
+// impactReport builds the report for a call graph, given the functions
+// that changed and the lines that changed in each. The callers of the
+// changed functions are found breadth first, so each function is
+// reported at the depth of its shortest path to a change.
+func impactReport(g *callGraph, changed []*callNode, lines map[*callNode][]int, depth int) []impactFunction {
+	level := map[*callNode]int{}
+	queue := []*callNode{}
+
+	for _, n := range changed {
+		if _, ok := level[n]; !ok {
+			level[n] = 0
+			queue = append(queue, n)
+		}
+	}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		if level[n] == depth {
+			continue
+		}
+
+		for _, e := range g.Callers(n) {
+			if _, ok := level[e.Caller]; !ok {
+				level[e.Caller] = level[n] + 1
+				queue = append(queue, e.Caller)
+			}
+		}
+	}
+
+	var report []impactFunction
+
+	for _, n := range g.Nodes {
+		l, ok := level[n]
+		if !ok {
+			continue
+		}
+
+		f := impactFunction{
+			Name:    n.Name,
+			File:    n.File,
+			Line:    n.Line,
+			Depth:   l,
+			Changed: lines[n],
+		}
+
+		if l > 0 {
+			for _, e := range g.Callees(n) {
+				if cl, ok := level[e.Callee]; !ok || cl != l-1 {
+					continue
+				}
+
+				for _, site := range e.Lines {
+					f.Calls = append(f.Calls, impactCall{
+						Callee: e.Callee.Name,
+						File:   e.File,
+						Line:   site,
+					})
+				}
+			}
+		}
+
+		report = append(report, f)
+	}
+
+	return report
+}
+
+// impactResults converts a report to cscope results, with the depth of
+// each result in depths. The functions that changed are reported at
+// their definitions, and their callers at each call site, with the
+// calling function as the Symbol.
+func impactResults(report []impactFunction) ([]cscope.Result, []int) {
+	var results []cscope.Result
+	var depths []int
+
+	for _, f := range report {
+		if f.Depth == 0 {
+			results = append(results, cscope.Result{
+				File:   f.File,
+				Line:   f.Line,
+				Symbol: f.Name,
+				Text:   "-",
+			})
+
+			depths = append(depths, 0)
+		}
+
+		for _, c := range f.Calls {
+			results = append(results, cscope.Result{
+				File:   c.File,
+				Line:   c.Line,
+				Symbol: f.Name,
+				Text:   "-",
+			})
+
+			depths = append(depths, f.Depth)
+		}
+	}
+
+	return results, depths
+}
+
+// impact implements the "impact" subcommand, which reports the
+// functions that a patch changes, and their callers.
+func impact(args []string) error {
+	flags := pflag.NewFlagSet("impact", pflag.ContinueOnError)
+	depth := flags.IntP("depth", "n", 3, "Number of levels of callers to report")
+	format := flags.String("format", "cscope", "Write the report as 'cscope' lines or 'json'")
+	strip := flags.IntP("strip", "p", 1, "Number of leading components to remove from patch paths")
+	dir := flags.String("dir", "", "Directory that patch paths are relative to (default: the workspace root)")
+	indexTimeout := flags.Duration("index-timeout", defaultIndexTimeout, "How long to wait for the language servers to index the workspace (0 to not wait)")
+
+	flags.AddFlagSet(sessionFlags)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s impact [OPTION...] [PATCH]\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nReports the functions that a unified diff changes, and their callers.\n")
+		fmt.Fprintf(os.Stderr, "The diff is read from PATCH, or from stdin if PATCH is missing or \"-\".\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	if *depth < 0 {
+		return fmt.Errorf("invalid depth %d", *depth)
+	}
+
+	if *format != "cscope" && *format != "json" {
+		return fmt.Errorf("invalid format '%s'", *format)
+	}
+
+	var in io.Reader = os.Stdin
+
+	if flags.NArg() == 1 && flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		in = f
+	}
+
+	files, err := diff.Parse(in, *strip)
+	if err != nil {
+		return err
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.close()
+
+	paths := sess.opts.Paths
+
+	if *dir == "" {
+		*dir = paths.wd
+		if len(sess.ws.Roots) > 0 {
+			*dir = sess.ws.Roots[0].Dir
+		}
+	}
+
+	// Files in different languages have separate graphs.
+	var graphs []*callGraph
+
+	clients := map[*client]*callGraph{}
+	changed := map[*callGraph][]*callNode{}
+	lines := map[*callNode][]int{}
+
+	for _, f := range files {
+		path := filepath.Join(*dir, f.Path)
+
+		// Skip files that were deleted, or that no server
+		// understands, like documentation.
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		if _, ok := sess.reg.languages[lsp.FileToLanguageID(path)]; !ok {
+			continue
+		}
+
+		c, _, err := openPosition(sess.reg, &queryPosition{File: path})
+		if err != nil {
+			return err
+		}
+
+		// A server that is still indexing reports only the
+		// callers that it has found so far.
+		c.waitIndexed(*indexTimeout)
+
+		g, ok := clients[c]
+		if !ok {
+			g = newCallGraph(c, paths)
+			clients[c] = g
+			graphs = append(graphs, g)
+		}
+
+		syms, err := lsp.TextDocumentDocumentSymbol(c.srv, path)
+		if err != nil {
+			return err
+		}
+
+		doc, err := c.docs.Open(path)
+		if err != nil {
+			return err
+		}
+
+		enc := c.srv.PositionEncoding()
+
+		for _, fn := range enclosingFunctions(syms, f.Lines) {
+			line, col := namePosition(doc, fn.sym, enc)
+			col = lsp.ByteToCharacter(doc.Line(line), col, enc)
+
+			nodes, err := g.walk(path, line, col, true, *depth)
+			if err != nil {
+				return err
+			}
+
+			// Still report a function that the server has no
+			// call hierarchy for.
+			if len(nodes) == 0 {
+				nodes = []*callNode{g.node(fmt.Sprintf("%s:%d", path, line),
+					functionName(fn.sym.Name), paths.path(lsp.FileToURI(path)), line+1)}
+			}
+
+			for _, n := range nodes {
+				changed[g] = append(changed[g], n)
+				lines[n] = append(lines[n], fn.lines...)
+			}
+		}
+	}
+
+	var report []impactFunction
+
+	for _, g := range graphs {
+		if err := g.locate(); err != nil {
+			return err
+		}
+
+		report = append(report, impactReport(g, changed[g], lines, *depth)...)
+	}
+
+	// Omit the functions in excluded files.
+	kept := report[:0]
+
+	for _, f := range report {
+		if sess.opts.Filter.selectedName(f.File, paths.wd) {
+			kept = append(kept, f)
+		}
+	}
+
+	report = kept
+
+	sort.SliceStable(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+
+		if a.Depth != b.Depth {
+			return a.Depth < b.Depth
+		}
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		return a.Line < b.Line
+	})
+
+	if *format == "json" {
+		if report == nil {
+			report = []impactFunction{}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	results, depths := impactResults(report)
+	resolveTextForResults(sess.opts.Lines, paths.wd, results)
+
+	// Show the depth of each result, as for a deep caller query.
+	for i, r := range results {
+		r.Text = fmt.Sprintf("[%d] %s", depths[i], r.Text)
+		fmt.Println(r)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+)
+
+func TestNamePosition(t *testing.T) {
+	doc := &lsp.Document{
+		Text: "int addr;\n" +
+			"static int add(int a, int b)\n" +
+			"{\n" +
+			"/* é */ OPERATOR(+)\n" +
+			"}\n",
+	}
+
+	symbol := func(name string, line int, char int) *lsp.SymbolInformation {
+		return &lsp.SymbolInformation{
+			Name: name,
+			Location: lsp.Location{
+				Range: lsp.Range{
+					Start: lsp.Position{Line: line, Character: char},
+					End:   lsp.Position{Line: 4, Character: 1},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		sym  *lsp.SymbolInformation
+		enc  lsp.PositionEncoding
+		line int
+		col  int
+	}{
+		// "addr" doesn't match "add".
+		{symbol("add", 0, 0), lsp.PositionEncodingUTF16, 1, 11},
+		{symbol("ns::add(int, int)", 1, 0), lsp.PositionEncodingUTF16, 1, 11},
+		// The name isn't in the text, so the start of the range,
+		// after the "é", is converted to a byte column.
+		{symbol("operator+", 3, 8), lsp.PositionEncodingUTF16, 3, 9},
+		{symbol("operator+", 3, 8), lsp.PositionEncodingUTF32, 3, 9},
+		{symbol("operator+", 3, 9), lsp.PositionEncodingUTF8, 3, 9},
+	}
+
+	for _, tt := range tests {
+		line, col := namePosition(doc, tt.sym, tt.enc)
+		if line != tt.line || col != tt.col {
+			t.Errorf("%s in %s: got %d:%d, want %d:%d", tt.sym.Name, tt.enc, line, col, tt.line, tt.col)
+		}
+	}
+}
+
+// impactGraph returns a call graph in which twice and main call add,
+// main calls twice, and start calls main. The graph is built directly,
+// without a server.
+func impactGraph() (*callGraph, map[string]*callNode) {
+	g := newCallGraph(nil, nil)
+
+	nodes := map[string]*callNode{
+		"add":   g.node("add", "add", "util.c", 3),
+		"twice": g.node("twice", "twice", "main.c", 3),
+		"main":  g.node("main", "main", "main.c", 8),
+		"start": g.node("start", "start", "crt.c", 1),
+	}
+
+	g.addCall(nodes["twice"], nodes["add"], "main.c", 5)
+	g.addCall(nodes["main"], nodes["add"], "main.c", 10)
+	g.addCall(nodes["main"], nodes["twice"], "main.c", 10)
+	g.addCall(nodes["start"], nodes["main"], "crt.c", 2)
+	g.addCall(nodes["start"], nodes["main"], "crt.c", 4)
+
+	return g, nodes
+}
+
+func TestImpactReport(t *testing.T) {
+	g, nodes := impactGraph()
+
+	changed := []*callNode{nodes["add"]}
+	lines := map[*callNode][]int{nodes["add"]: {4, 5}}
+
+	add := impactFunction{Name: "add", File: "util.c", Line: 3, Depth: 0, Changed: []int{4, 5}}
+
+	// main calls both add and twice, but it is reported at the depth
+	// of its shortest path to the change, with only the calls that
+	// lead one level closer.
+	twice := impactFunction{Name: "twice", File: "main.c", Line: 3, Depth: 1, Calls: []impactCall{
+		{Callee: "add", File: "main.c", Line: 5},
+	}}
+
+	mainFunc := impactFunction{Name: "main", File: "main.c", Line: 8, Depth: 1, Calls: []impactCall{
+		{Callee: "add", File: "main.c", Line: 10},
+	}}
+
+	start := impactFunction{Name: "start", File: "crt.c", Line: 1, Depth: 2, Calls: []impactCall{
+		{Callee: "main", File: "crt.c", Line: 2},
+		{Callee: "main", File: "crt.c", Line: 4},
+	}}
+
+	tests := []struct {
+		name    string
+		changed []*callNode
+		depth   int
+		want    []impactFunction
+	}{
+		{"depth 0", changed, 0, []impactFunction{add}},
+		{"depth 1", changed, 1, []impactFunction{add, twice, mainFunc}},
+		{"depth 2", changed, 2, []impactFunction{add, twice, mainFunc, start}},
+		{"depth 3", changed, 3, []impactFunction{add, twice, mainFunc, start}},
+		{"nothing changed", nil, 3, nil},
+	}
+
+	for _, tt := range tests {
+		if got := impactReport(g, tt.changed, lines, tt.depth); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// A function that changed is at depth 0, even if it also calls
+	// another function that changed.
+	both := []*callNode{nodes["add"], nodes["main"]}
+	lines[nodes["main"]] = []int{9}
+
+	mainFunc = impactFunction{Name: "main", File: "main.c", Line: 8, Depth: 0, Changed: []int{9}}
+	start.Depth = 1
+
+	got := impactReport(g, both, lines, 1)
+	want := []impactFunction{add, twice, mainFunc, start}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("two changes: got %+v, want %+v", got, want)
+	}
+}
+
+func TestImpactResults(t *testing.T) {
+	report := []impactFunction{
+		{Name: "add", File: "util.c", Line: 3, Depth: 0, Changed: []int{4, 5}},
+		{Name: "twice", File: "main.c", Line: 3, Depth: 1, Calls: []impactCall{
+			{Callee: "add", File: "main.c", Line: 5},
+		}},
+		{Name: "start", File: "crt.c", Line: 1, Depth: 2, Calls: []impactCall{
+			{Callee: "main", File: "crt.c", Line: 2},
+			{Callee: "main", File: "crt.c", Line: 4},
+		}},
+	}
+
+	// Changed functions are reported at their definitions, and
+	// callers at each call site.
+	wantResults := []cscope.Result{
+		{File: "util.c", Line: 3, Symbol: "add", Text: "-"},
+		{File: "main.c", Line: 5, Symbol: "twice", Text: "-"},
+		{File: "crt.c", Line: 2, Symbol: "start", Text: "-"},
+		{File: "crt.c", Line: 4, Symbol: "start", Text: "-"},
+	}
+
+	wantDepths := []int{0, 1, 2, 2}
+
+	results, depths := impactResults(report)
+
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("got results %+v, want %+v", results, wantResults)
+	}
+
+	if !reflect.DeepEqual(depths, wantDepths) {
+		t.Errorf("got depths %v, want %v", depths, wantDepths)
+	}
+
+	if results, depths := impactResults(nil); len(results) != 0 || len(depths) != 0 {
+		t.Errorf("got %+v %v for an empty report", results, depths)
+	}
+}
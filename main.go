@@ -3,35 +3,66 @@ package main
 import (
 	"fmt"
 	"io"
-	"net/url"
+	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/jpeach/cscope-lsp/pkg/ccls"
-	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/compdb"
+	"github.com/jpeach/cscope-lsp/pkg/config"
 	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/linecache"
 	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
 
 	"github.com/spf13/pflag"
-	"golang.org/x/sys/unix"
 )
 
 const (
 	// PROGNAME is the program name used in error and log messages.
 	PROGNAME = "cscope-lsp"
+
+	// lineCacheSize is the number of files whose lines we keep
+	// cached for showing result text.
+	lineCacheSize = 128
+
+	// defaultIndexTimeout is how long the impact and unused
+	// commands wait for the servers to index the workspace.
+	defaultIndexTimeout = 10 * time.Minute
 )
 
 var (
-	cqueryPath = pflag.StringP("cquery", "c", "clangd", "Path to the cquery binary")
-	debugLsp   = pflag.Bool("debug-lsp", false, "Enable cquery debug output")
-	helpFlag   = pflag.BoolP("help", "h", false, "Print this help message")
-	traceFile  = pflag.String("trace", "", "Trace cscope messages to the given file")
-	traceLsp   = pflag.Bool("trace-lsp", true, "Trace LSP messages to the trace file")
+	// sessionFlags configure the language servers and how results
+	// are processed. The subcommands that start a session accept
+	// them too.
+	sessionFlags = pflag.NewFlagSet("session", pflag.ContinueOnError)
+
+	configFile = sessionFlags.String("config", "", "Path to the configuration file (default: search for "+config.FileName+")")
+	cqueryPath = sessionFlags.StringP("cquery", "c", "clangd", "Path to the C/C++ language server binary")
+	callDepth  = sessionFlags.Int("call-depth", 1, "Number of levels of the call hierarchy that caller and callee queries search")
+	debugLsp   = sessionFlags.Bool("debug-lsp", false, "Enable cquery debug output")
+	excludes   = sessionFlags.StringSlice("exclude", nil, "Omit results from files matching the given glob patterns")
+	includes   = sessionFlags.StringSlice("include", nil, "Only report results from files matching the given glob patterns")
+	scanFilter = sessionFlags.Bool("filter-scans", true, "Apply the include and exclude patterns when scanning files for text, egrep and file queries")
+	langFlag   = sessionFlags.StringSlice("language", nil, "Map a file extension to a LSP language ID (e.g. 'inc=cpp')")
+	launchFlag = sessionFlags.StringArray("launcher", nil, "Word of the command prefix used to start language servers that have no launcher, repeated for each word (e.g. --launcher docker --launcher exec)")
+	pathMap    = sessionFlags.StringArray("path-map", nil, "Map a host directory to the language servers' file system (e.g. '~/work/proj=/src')")
+	orderFlag  = sessionFlags.String("order", orderCscope, "Order results by 'cscope' (definitions, then the queried file, then by path), 'path' or 'server'")
+	openDocs   = sessionFlags.Int("open-documents", 32, "Maximum number of documents to keep open in the LSP server")
+	serverFlag = sessionFlags.StringArray("server", nil, "Use a language server for a list of language IDs (e.g. 'go=gopls')")
+	timeout    = sessionFlags.Duration("timeout", 0, "Maximum time to wait for each LSP request (0 waits forever)")
+	traceFile  = sessionFlags.String("trace", "", "Trace cscope queries to the given file")
+	recordFile = sessionFlags.String("record", "", "Record LSP sessions to the given file")
+	replayFile = sessionFlags.String("replay", "", "Replay LSP sessions from the given recording instead of starting servers")
+	rootFlag   = sessionFlags.StringArray("root", nil, "Add a workspace root directory (may be repeated)")
+	traceLsp   = sessionFlags.Bool("trace-lsp", true, "Trace LSP messages to the trace file")
+	watchDelay = sessionFlags.Duration("watch-delay", 500*time.Millisecond, "Delay before reporting file changes to the LSP server (0 disables file watching)")
+
+	helpFlag = pflag.BoolP("help", "h", false, "Print this help message")
 
 	// The following flags are required for cscope compatibility. Vim will
 	// set them when starting up the line-oriented interface, but we only
@@ -42,80 +73,188 @@ var (
 	_        = pflag.StringP("prepend", "P", "", "Prepend path to relative file names in pre-built cross-ref file (*)")
 )
 
-func lspInit(opts []lsp.ServerOption) (*lsp.Server, error) {
-	srv, err := lsp.NewServer()
+func init() {
+	pflag.CommandLine.AddFlagSet(sessionFlags)
+}
+
+// queryPosition is the document position parsed from a cscope
+// query pattern.
+type queryPosition struct {
+	// File is the absolute path of the queried file.
+	File string
+
+	// Line and Col are the 0-based LSP position.
+	Line int
+	Col  int
+
+	// Depth is the number of levels of the call hierarchy to
+	// search, or 0 to use the default.
+	Depth int
+
+	// Buffer is the path to a file holding the current (possibly
+	// unsaved) editor buffer for File. It is empty if the query
+	// should use the on-disk content of File.
+	Buffer string
+}
 
+// Match a query pattern of the form "file:line:col" with an optional
+// "#depth" suffix, and then an optional "@buffer" suffix.
+var matchQueryPattern = regexp.MustCompile(`^(.+):([^:@#]+):([^:@#]+)(?:#([^:@#]+))?(?:@(.+))?$`)
+
+func parseQueryPattern(spec string) (*queryPosition, error) {
+	parts := matchQueryPattern.FindStringSubmatch(spec)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid document position")
+	}
+
+	file, err := filepath.Abs(parts[1])
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid file '%s': %s", parts[1], err)
 	}
 
-	if err = srv.Start(opts); err != nil {
-		return nil, fmt.Errorf("failed to start LSP server: %s", err)
+	line, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid line number '%s': %s", parts[2], err)
 	}
 
-	cwd, err := os.Getwd()
+	col, err := strconv.Atoi(parts[3])
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid column number '%s': %s", parts[3], err)
 	}
 
-	var init interface{}
+	depth := 0
 
-	if strings.Contains(*cqueryPath, "ccls") {
-		init = ccls.InitializationOptions{
-			Cache: ccls.CacheOptions{
-				Directory:        path.Join(cwd, ".ccls"),
-				HierarchicalPath: true,
-				Format:           "binary",
-			},
-		}
-	} else if strings.Contains(*cqueryPath, "cquery") {
-		init = cquery.InitializationOptions{
-			CacheDirectory: path.Join(cwd, ".cquery"),
+	if parts[4] != "" {
+		depth, err = strconv.Atoi(parts[4])
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("invalid call depth '%s'", parts[4])
 		}
 	}
 
-	if err := lsp.Initialize(srv, cwd, init); err != nil {
-		return nil, fmt.Errorf("LSP initialization failed: %s", err)
-	}
+	// NOTE: Comvert from Vim 1-based indices, to LSP 0-based.
+	return &queryPosition{
+		File:   file,
+		Line:   line - 1,
+		Col:    col - 1,
+		Depth:  depth,
+		Buffer: parts[5],
+	}, nil
+}
 
-	return srv, nil
+// pathMapper maps the URIs in LSP results to the paths that we report
+// to vim. Vim resolves relative paths against its working directory,
+// which need not be the workspace root.
+type pathMapper struct {
+	// wd is the vim working directory.
+	wd string
+
+	// ws holds the workspace roots.
+	ws *workspace.Workspace
+
+	// realDirs maps the directory of each root to its
+	// symlink-resolved path, or to an empty string if the path
+	// can't be resolved. Roots are resolved when they are first
+	// needed, since roots can be added during a session.
+	realDirs map[string]string
 }
 
-func parseQueryPattern(spec string) (string, int, int, error) {
-	parts := strings.Split(spec, ":")
-	if len(parts) != 3 {
-		return "", 0, 0, fmt.Errorf("invalid document position")
+// newPathMapper returns a pathMapper for the workspace ws, and the
+// current working directory.
+func newPathMapper(ws *workspace.Workspace) *pathMapper {
+	wd, _ := os.Getwd()
+
+	return &pathMapper{
+		wd: wd,
+		ws: ws,
 	}
+}
 
-	file, err := filepath.Abs(parts[0])
+// rel returns path relative to the working directory, or path itself
+// if there is no relative path.
+func (m *pathMapper) rel(path string) string {
+	rel, err := filepath.Rel(m.wd, path)
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("invalid file '%s': %s", parts[0], err)
+		return path
 	}
 
-	line, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return "", 0, 0, fmt.Errorf("invalid line number '%s': %s", parts[1], err)
+	return rel
+}
+
+// unresolve maps a path whose symlinks the server resolved back to the
+// corresponding path under a workspace root. If the resolved roots are
+// nested, the innermost root that contains the path is used. It returns
+// an empty string if the path is not in any root.
+func (m *pathMapper) unresolve(path string) string {
+	var best *workspace.Root
+	var bestReal string
+
+	if m.realDirs == nil {
+		m.realDirs = map[string]string{}
 	}
 
-	col, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return "", 0, 0, fmt.Errorf("invalid column number '%s': %s", parts[1], err)
+	for _, r := range m.ws.Roots {
+		real, ok := m.realDirs[r.Dir]
+		if !ok {
+			real, _ = filepath.EvalSymlinks(r.Dir)
+			m.realDirs[r.Dir] = real
+		}
+
+		if real == "" || len(real) <= len(bestReal) {
+			continue
+		}
+
+		rel, err := filepath.Rel(real, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		best, bestReal = r, real
 	}
 
-	// NOTE: Comvert from Vim 1-based indices, to LSP 0-based.
-	return file, line - 1, col - 1, nil
+	if best == nil {
+		return ""
+	}
+
+	rel, _ := filepath.Rel(bestReal, path)
+	return filepath.Join(best.Dir, rel)
 }
 
-func uriToPath(wd string, uri string) string {
-	u, err := url.Parse(uri)
+// path returns the path for a result URI. Files in any workspace root
+// are reported relative to the working directory, even if they are
+// outside it, and other files by their absolute path. Servers often
+// report paths with symlinks resolved, so those are mapped back to
+// the workspace roots. URIs that are not for local files are reported
+// unchanged.
+func (m *pathMapper) path(uri string) string {
+	path, err := lsp.URIToPath(uri)
 	if err != nil {
-		panic(fmt.Sprintf("failed to parse URI '%s': %s", uri, err))
+		return uri
+	}
+
+	if m.ws.Find(path) != nil {
+		return m.rel(path)
+	}
+
+	if p := m.unresolve(path); p != "" {
+		return m.rel(p)
+	}
+
+	// The server might also have given us a path through a
+	// symlink that leads into a root.
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		if m.ws.Find(real) != nil {
+			return m.rel(real)
+		}
+
+		if p := m.unresolve(real); p != "" {
+			return m.rel(p)
+		}
 	}
 
-	return strings.TrimPrefix(u.Path, wd+"/")
+	return path
 }
 
-func convertLocationsToResult(wd string, loc []lsp.Location) ([]cscope.Result, error) {
+func convertLocationsToResult(paths *pathMapper, loc []lsp.Location) ([]cscope.Result, error) {
 	results := make([]cscope.Result, 0, len(loc))
 
 	for _, l := range loc {
@@ -127,7 +266,7 @@ func convertLocationsToResult(wd string, loc []lsp.Location) ([]cscope.Result, e
 
 		// NOTE: We convert LSP 0-based lines back to Vim 1-based lines.
 		r := cscope.Result{
-			File:   uriToPath(wd, l.URI),
+			File:   paths.path(l.URI),
 			Line:   l.Range.Start.Line + 1,
 			Symbol: "-",
 			Text:   "-",
@@ -139,26 +278,10 @@ func convertLocationsToResult(wd string, loc []lsp.Location) ([]cscope.Result, e
 	return results, nil
 }
 
-func convertCallsToResult(wd string, calls *cquery.CallHierarchy) ([]cscope.Result, error) {
-	results := make([]cscope.Result, 0, len(calls.Children))
-
-	for _, c := range calls.Children {
-		// NOTE: We convert LSP 0-based lines back to Vim 1-based lines.
-		r := cscope.Result{
-			File:   uriToPath(wd, c.Location.URI),
-			Line:   c.Location.Range.Start.Line + 1,
-			Symbol: "-",
-			Text:   c.Name,
-		}
-
-		results = append(results, r)
-	}
-
-	return results, nil
-
-}
-
-func resolveContainerForLocation(s *lsp.Server, results []cscope.Result, loc []lsp.Location) error {
+// resolveContainerForLocation sets the Symbol of each result to the
+// name of the symbol that contains its location. It returns the
+// symbols in each file, by URI.
+func resolveContainerForLocation(s *lsp.Server, results []cscope.Result, loc []lsp.Location) (map[string][]lsp.SymbolInformation, error) {
 	// Map of file path to all the symbols in that file.
 	syms := map[string][]lsp.SymbolInformation{}
 
@@ -170,7 +293,7 @@ func resolveContainerForLocation(s *lsp.Server, results []cscope.Result, loc []l
 
 		sym, err := lsp.TextDocumentDocumentSymbol(s, l.URI)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Make sure the symbols are sorted by their start position.
@@ -247,98 +370,291 @@ func resolveContainerForLocation(s *lsp.Server, results []cscope.Result, loc []l
 		}
 	}
 
-	return nil
+	return syms, nil
 }
 
-func resolveTextForResults(results []cscope.Result) error {
+// resolveTextForResults sets the Text of each result to its source
+// line. Relative result paths are relative to the working directory
+// wd. Results whose line can't be read keep their existing Text, since
+// a stale line number shouldn't fail the whole query.
+func resolveTextForResults(lines *linecache.Cache, wd string, results []cscope.Result) {
+	for i, r := range results {
+		path := r.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(wd, path)
+		}
 
-	// Map of file path to all the lines in that file.
-	lines := map[string][]string{}
+		if text, ok, err := lines.Line(path, r.Line-1); err == nil && ok {
+			results[i].Text = text
+		}
+	}
+}
 
-	for _, r := range results {
-		lines[r.File] = make([]string, 0)
+// resolveTextFromBuffer sets the Text of each result in the file of
+// doc to its line in doc. This is used for an unsaved editor buffer,
+// whose lines need not match the file on disk.
+func resolveTextFromBuffer(doc *lsp.Document, wd string, results []cscope.Result) {
+	for i, r := range results {
+		path := r.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(wd, path)
+		}
+
+		if path == doc.Path {
+			results[i].Text = doc.Line(r.Line - 1)
+		}
 	}
+}
 
-	for f := range lines {
-		fd, err := unix.Open(f, unix.O_RDONLY, 0)
-		if err != nil {
-			return fmt.Errorf("failed to open %s: %s", f, err)
+// lineReader returns the text of a 0-based line of a file, without
+// the line terminator.
+type lineReader interface {
+	Line(n int) string
+}
+
+// cachedFile reads the lines of a file on disk from a line cache.
+type cachedFile struct {
+	lines *linecache.Cache
+	path  string
+}
+
+func (f cachedFile) Line(n int) string {
+	text, _, _ := f.lines.Line(f.path, n)
+	return text
+}
+
+// isDefinitionPosition returns true if the position at line and col,
+// in the server's encoding, is on the name of a symbol that is defined
+// in the file whose lines are in text. A function symbol that fits on
+// one line is taken to be a declaration, such as a prototype, rather
+// than a definition.
+func isDefinitionPosition(text lineReader, syms []lsp.SymbolInformation, line int, col int, enc lsp.PositionEncoding) bool {
+	for i := range syms {
+		sym := &syms[i]
+		rng := sym.Location.Range
+
+		if rng.Start.Line > line || rng.End.Line < line {
+			continue
 		}
 
-		var s unix.Stat_t
-		unix.Fstat(fd, &s)
+		if isFunctionSymbol(sym.Kind) && rng.Start.Line == rng.End.Line {
+			continue
+		}
 
-		// TODO(jpeach): on Linux use unix.MAP_POPULATE to trigger
-		// readahead.
-		ptr, err := unix.Mmap(fd, 0, int(s.Size), unix.PROT_READ, unix.MAP_FILE|unix.MAP_SHARED)
-		if err != nil {
-			return fmt.Errorf("failed to mmap %s: %s", f, err)
+		l, start := namePosition(text, sym, enc)
+		if l != line {
+			continue
 		}
 
-		// TODO(jpeach): convert ptr to string without copying ...
-		lines[f] = strings.Split(string(ptr), "\n")
+		t := text.Line(l)
+		end := start + len(unqualifiedName(sym.Name))
 
-		defer unix.Munmap(ptr)
-		defer unix.Close(fd)
+		if end > len(t) {
+			continue
+		}
+
+		if col >= lsp.ByteToCharacter(t, start, enc) && col < lsp.ByteToCharacter(t, end, enc) {
+			return true
+		}
 	}
 
-	for i, r := range results {
-		results[i].Text = lines[r.File][r.Line-1]
+	return false
+}
+
+// searchOptions control how search results are processed.
+type searchOptions struct {
+	// Order is the result ordering, one of orderCscope, orderPath
+	// or orderServer.
+	Order string
+
+	// Filter selects the files that results may come from.
+	Filter *pathFilter
+
+	// FilterScans applies Filter to the text, egrep and file
+	// queries that scan the workspace.
+	FilterScans bool
+
+	// Lines caches the source lines shown in results.
+	Lines *linecache.Cache
+
+	// CallDepth is the default number of levels of the call
+	// hierarchy that caller and callee queries search.
+	CallDepth int
+
+	// Paths maps result URIs to the paths that are reported.
+	Paths *pathMapper
+}
+
+// finishResults filters, deduplicates and sorts the results of a
+// query. The filter may be nil. The origin is the reported path of
+// the queried file, and defs holds the results that are definitions.
+// If tree is true, the results are a flattened call hierarchy, whose
+// order matters and in which the same line can appear at different
+// levels, so they are only filtered.
+func finishResults(results []cscope.Result, opts *searchOptions, filter *pathFilter, wd string, origin string, defs map[resultKey]bool, tree bool) []cscope.Result {
+	if filter != nil {
+		results = filter.filter(results, wd)
 	}
 
-	return nil
+	if tree {
+		return results
+	}
+
+	results = dedupResults(results)
+	sortResults(results, opts.Order, origin, defs)
+
+	return results
 }
 
-func mtime(path string) (int, error) {
-	s, err := os.Stat(path)
+// openPosition opens the document of a query position in the language
+// server for its language, and returns the client and the column of the
+// position in the server's position encoding.
+func openPosition(reg *registry, pos *queryPosition) (*client, int, error) {
+	if err := reg.include(pos.File); err != nil {
+		return nil, 0, err
+	}
+
+	c, err := reg.client(lsp.FileToLanguageID(pos.File))
 	if err != nil {
-		return 0, err
+		return nil, 0, err
+	}
+
+	// If cquery can't find the symbol, it will crash unless the
+	// document is open. The document manager keeps recently queried
+	// documents open, and updates them when they change on disk.
+	// If the query carries an editor buffer, send that instead so
+	// that positions in an unsaved buffer are correct.
+	var doc *lsp.Document
+
+	if pos.Buffer != "" {
+		text, err := ioutil.ReadFile(pos.Buffer)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if doc, err = c.docs.Update(pos.File, string(text)); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		if doc, err = c.docs.Open(pos.File); err != nil {
+			return nil, 0, err
+		}
 	}
 
-	return int(s.ModTime().Unix()), nil
+	// Vim gives us a byte column, but the server counts characters
+	// in the negotiated position encoding.
+	return c, lsp.ByteToCharacter(doc.Line(pos.Line), pos.Col, c.srv.PositionEncoding()), nil
 }
 
-func search(s *lsp.Server, q *cscope.Query) ([]cscope.Result, error) {
-	wd, _ := os.Getwd()
+func search(reg *registry, q *cscope.Query, opts *searchOptions) ([]cscope.Result, error) {
+	paths := opts.Paths
+	wd := paths.wd
+
+	switch q.Search {
+	case cscope.FindTextString, cscope.FindEgrepPattern, cscope.FindFile:
+		var filter *pathFilter
+
+		if opts.FilterScans {
+			filter = opts.Filter
+		}
 
-	file, line, col, err := parseQueryPattern(q.Pattern)
+		results, err := scan(reg.ws, paths, filter, q)
+		if err != nil {
+			return nil, err
+		}
+
+		return finishResults(results, opts, filter, wd, "", nil, false), nil
 
-	// Use the mtime as the file version since it will increment
-	// when the file changes
-	vers, err := mtime(file)
+	case cscope.FindCallPath:
+		// Filtering would break the chains, so the results are
+		// returned as they are.
+		return searchCallPath(reg, paths, q.Pattern, opts)
+	}
+
+	pos, err := parseQueryPattern(q.Pattern)
 	if err != nil {
 		return nil, err
 	}
 
-	// If cquery can't find the symbol, it will crash unless the document
-	// is open. Work around that by always opening the doc just in case.
-	if err := lsp.TextDocumentDidOpen(s, file, vers); err != nil {
+	file, line := pos.File, pos.Line
+
+	depth := opts.CallDepth
+	if pos.Depth > 0 {
+		depth = pos.Depth
+	}
+
+	c, col, err := openPosition(reg, pos)
+	if err != nil {
 		return nil, err
 	}
 
-	defer lsp.TextDocumentDidClose(s, file)
+	s := c.srv
+
+	// The text of results in a queried editor buffer comes from the
+	// buffer, since the line numbers that the server reports are
+	// for the buffer, not the file on disk.
+	resolveText := func(r []cscope.Result) {
+		resolveTextForResults(opts.Lines, wd, r)
+
+		if pos.Buffer != "" {
+			if doc := c.docs.Lookup(file); doc != nil {
+				resolveTextFromBuffer(doc, wd, r)
+			}
+		}
+	}
+
+	var results []cscope.Result
+
+	// defs holds the results that are definitions, for ordering.
+	defs := map[resultKey]bool{}
+
+	// tree is true if the results are a flattened call hierarchy.
+	tree := false
 
 	switch q.Search {
 	case cscope.FindSymbol:
-		loc, err := lsp.TextDocumentReferences(s, file, line, col)
+		loc, err := lsp.TextDocumentReferences(s, file, line, col, true)
 		if err != nil {
 			return nil, err
 		}
 
-		r, err := convertLocationsToResult(wd, loc)
+		r, err := convertLocationsToResult(paths, loc)
 		if err != nil {
 			return nil, err
 		}
 
-		if err = resolveTextForResults(r); err != nil {
+		resolveText(r)
+
+		syms, err := resolveContainerForLocation(s, r, loc)
+		if err != nil {
 			return nil, err
 		}
 
-		if err = resolveContainerForLocation(s, r, loc); err != nil {
-			return nil, err
+		// The definitions only affect the ordering. Rather than
+		// ask the server, they are found among the references,
+		// which include the declarations, from the symbols that
+		// were fetched to resolve the containers.
+		if opts.Order == orderCscope {
+			enc := s.PositionEncoding()
+
+			for i, l := range loc {
+				path, err := lsp.URIToPath(l.URI)
+				if err != nil {
+					continue
+				}
+
+				var text lineReader = cachedFile{lines: opts.Lines, path: path}
+				if doc := c.docs.Lookup(path); doc != nil {
+					text = doc
+				}
+
+				if isDefinitionPosition(text, syms[l.URI], l.Range.Start.Line, l.Range.Start.Character, enc) {
+					defs[keyOf(r[i])] = true
+				}
+			}
 		}
 
-		return r, nil
+		results = r
 
 	case cscope.FindDefinition:
 		loc, err := lsp.TextDocumentImplementation(s, file, line, col)
@@ -360,41 +676,33 @@ func search(s *lsp.Server, q *cscope.Query) ([]cscope.Result, error) {
 			}
 		}
 
-		r, err := convertLocationsToResult(wd, loc)
+		r, err := convertLocationsToResult(paths, loc)
 		if err != nil {
 			return nil, err
 		}
 
-		if err = resolveTextForResults(r); err != nil {
-			return nil, err
-		}
+		resolveText(r)
 
-		return r, nil
+		results = r
 
-	case cscope.FindCallees:
-		calls, err := cquery.CalleeHierarchy(s, file, line, col)
+	case cscope.FindCallees, cscope.FindCallers:
+		r, levels, err := searchCalls(c, paths, file, line, col, q.Search == cscope.FindCallers, depth)
 		if err != nil {
 			return nil, err
 		}
 
-		return convertCallsToResult(wd, calls)
+		resolveText(r)
 
-	case cscope.FindCallers:
-		calls, err := cquery.CallerHierarchy(s, file, line, col)
-		if err != nil {
-			return nil, err
-		}
-
-		return convertCallsToResult(wd, calls)
-
-	case cscope.FindTextString:
-		return nil, fmt.Errorf("not implemented")
+		// Show the level of each result in a deeper hierarchy.
+		if depth > 1 {
+			for i := range r {
+				r[i].Text = fmt.Sprintf("[%d] %s", levels[i], r[i].Text)
+			}
 
-	case cscope.FindEgrepPattern:
-		return nil, fmt.Errorf("not implemented")
+			tree = true
+		}
 
-	case cscope.FindFile:
-		return nil, fmt.Errorf("not implemented")
+		results = r
 
 	case cscope.FindIncludingFiles:
 		return nil, fmt.Errorf("not implemented")
@@ -403,110 +711,218 @@ func search(s *lsp.Server, q *cscope.Query) ([]cscope.Result, error) {
 		return nil, fmt.Errorf("invalid cscope search type '%d'", q.Search)
 	}
 
+	origin := paths.path(lsp.FileToURI(file))
+
+	return finishResults(results, opts, opts.Filter, wd, origin, defs, tree), nil
 }
 
-func main() {
-	pflag.Parse()
+// loadConfig loads the configuration file named by the --config flag,
+// or the nearest configuration file in the current directory or its
+// parents. If there is no configuration file, it returns an empty
+// Config.
+func loadConfig() (*config.Config, error) {
+	path := *configFile
 
-	if *helpFlag {
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTION...]\n", PROGNAME)
-		fmt.Fprintf(os.Stderr, "\nOptions:\n")
-		pflag.PrintDefaults()
-		os.Exit(0)
+	if path == "" {
+		var err error
+
+		if path, err = config.Find("."); err != nil {
+			return nil, err
+		}
 	}
 
-	conn := cscope.Conn{
-		In:  os.Stdin,
-		Out: os.Stdout,
+	if path == "" {
+		return &config.Config{}, nil
 	}
 
-	lspOpts := []lsp.ServerOption{
-		lsp.OptPath(*cqueryPath),
+	return config.Load(path)
+}
+
+// openWorkspace returns the workspace for the root that contains the
+// current directory, together with the given additional roots. If the
+// current directory is not in a recognizable source tree, but is in
+// one of the additional roots, the additional roots are used alone.
+func openWorkspace(dirs []string) (*workspace.Workspace, error) {
+	primary, err := workspace.FindRoot(".")
+	if err != nil {
+		return nil, err
 	}
 
-	if *traceFile != "" {
-		traceFd, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	var roots []*workspace.Root
+
+	for _, d := range dirs {
+		r, err := workspace.OpenRoot(d)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid root: %s", err)
 		}
 
-		defer traceFd.Close()
+		if primary != nil && primary.Marker == "" && r.Contains(primary.Dir) {
+			primary = nil
+		}
+
+		roots = append(roots, r)
+	}
+
+	ws := &workspace.Workspace{}
+
+	if primary != nil {
+		ws.Add(primary)
+	}
+
+	for _, r := range roots {
+		ws.Add(r)
+	}
 
-		conn = cscope.Conn{
-			In:  io.TeeReader(os.Stdin, traceFd),
-			Out: io.MultiWriter(os.Stdout, traceFd),
+	return ws, nil
+}
+
+// headerLanguage returns a resolver that guesses whether ambiguous
+// headers are C, C++ or Objective-C from the compilation database of
+// the workspace root that contains them.
+func headerLanguage(ws *workspace.Workspace) func(string) string {
+	dbs := map[string]*compdb.Database{}
+
+	return func(path string) string {
+		r := ws.Find(path)
+		if r == nil || r.CompilationDatabase == "" {
+			return ""
 		}
 
-		os.Stderr = traceFd
+		db, ok := dbs[r.CompilationDatabase]
+		if !ok {
+			// Remember failures too, so that we only try once.
+			db, _ = compdb.Load(filepath.Join(r.CompilationDatabase, compdb.FileName))
+			dbs[r.CompilationDatabase] = db
+		}
 
-		if *traceLsp {
-			lspOpts = append(lspOpts,
-				lsp.OptTrace(traceFd),
-			)
+		if db == nil {
+			return ""
 		}
+
+		return db.HeaderLanguage(path)
 	}
+}
 
-	if *debugLsp {
-		lspOpts = append(lspOpts,
-			lsp.OptArgs([]string{
-				"--log-all-to-stderr",
-			}),
-		)
+// commands are the subcommands, which are run as
+// "cscope-lsp COMMAND [ARGS...]" instead of the cscope interface.
+var commands = map[string]func(args []string) error{
+	"callgraph":   callGraphCommand,
+	"client":      cscopeClient,
+	"impact":      impact,
+	"path":        callPath,
+	"trace-stats": traceStats,
+	"unused":      unused,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				if err != pflag.ErrHelp {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
+				}
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 	}
 
-	// TODO(jpeach): If we need to restart the LSP server, we also need
-	// to re-initialize it. This probably means that we need to drive the
-	// restart from the main loop here rather than automatically in the
-	// lsp.Server.
-	srv, err := lspInit(lspOpts)
+	pflag.Parse()
+
+	if *helpFlag {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTION...]\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "       %s COMMAND [OPTION...] [ARG...]\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nCommands: %s\n", strings.Join(names, ", "))
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		pflag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
+		os.Exit(1)
+	}
+
+	// Close the session before exiting, since os.Exit skips
+	// deferred calls.
+	if *lineFlag {
+		err = serve(sess, os.Stdin, os.Stdout)
+	}
+
+	sess.close()
+
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: failed to start %s: %s\n",
-			PROGNAME, *cqueryPath, err)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
 		os.Exit(1)
 	}
+}
+
+// serve answers cscope line-oriented queries from in until it reaches
+// the end of the input or vim quits. Each query is traced if the
+// session has a tracer. It returns an error if it can't start the
+// C/C++ server or write results.
+func serve(sess *session, in io.Reader, out io.Writer) error {
+	reg, opts, tracer := sess.reg, sess.opts, sess.tracer
+
+	conn := cscope.Conn{
+		In:  in,
+		Out: out,
+	}
 
-	defer srv.Stop()
+	// Start the C/C++ server eagerly so that it can begin indexing
+	// before the first query. Other servers are started on demand.
+	if _, err := reg.client("cpp"); err != nil {
+		return err
+	}
 
-	for *lineFlag {
+	for {
 		conn.Prompt()
 
 		query, err := conn.Read()
 		if err == io.EOF || err == cscope.ErrQuit {
-			os.Exit(0)
+			return nil
 		}
 
+		// Vim waits for a result count after every query, so
+		// we have to send an empty result even for bad queries.
 		if err != nil {
-			conn.Out.Write([]byte(fmt.Sprintf("%s: %s\n", PROGNAME, err)))
+			fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
+			conn.Write([]cscope.Result{})
 			continue
 		}
 
-		results, err := search(srv, query)
+		start := time.Now()
+		results, err := search(reg, query, opts)
+
+		if tracer != nil {
+			tracer.Query(int(query.Search), query.Pattern, len(results), time.Since(start), err)
+		}
 
 		switch err {
 		case nil:
 			if err = conn.Write(results); err != nil {
-				fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
-				os.Exit(1)
+				return err
 			}
 
 		// Unfortunately, if we just exit on any error, vim
-		// doesn't restart us, so if the LSP server stops for
-		// any reason, we just restart it. The user experience
-		// will be that one cscope search fails, but subsequent
-		// ones will succeed.
+		// doesn't restart us, so if a LSP server stops for
+		// any reason, we restart it on the next query. The user
+		// experience will be that one cscope search fails, but
+		// subsequent ones will succeed.
 		case lsp.ErrStopped:
 			if err = conn.Write(results); err != nil {
-				fmt.Fprintf(os.Stderr, "%s: %s\n", PROGNAME, err)
-				os.Exit(1)
+				return err
 			}
 
-			srv, err = lspInit(lspOpts)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: failed to start %s: %s\n",
-					PROGNAME, *cqueryPath, err)
-				os.Exit(1)
-			}
+			reg.prune()
 
 		default:
 			// If we get an error from the LSP server, we can show
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/linecache"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+	"github.com/jpeach/cscope-lsp/pkg/replay"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+// replayRegistry returns a registry whose C server replays
+// testdata/replay/clangd.jsonl. That file is a hand-written fixture in
+// the --record format, not a recording of a real clangd session. Its
+// server sees the sources in testdata/src at /src, as if it ran in a
+// container.
+func replayRegistry(t *testing.T) *registry {
+	t.Helper()
+
+	f, err := os.Open("testdata/replay/clangd.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	rec, err := replay.Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := workspace.OpenRoot("testdata/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &workspace.Workspace{}
+	ws.Add(root)
+
+	reg := newRegistry(ws, nil)
+	reg.replay = rec
+	reg.register(&serverConfig{
+		Path:  "clangd",
+		Paths: lsp.PathMap{{Host: root.Dir, Server: "/src"}},
+	}, "c")
+
+	t.Cleanup(reg.stop)
+
+	return reg
+}
+
+func TestSearchReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		search cscope.SearchType
+		query  string
+		want   []string
+	}{{
+		name:   "definition",
+		search: cscope.FindDefinition,
+		query:  "testdata/src/main.c:5:9",
+		want: []string{
+			"testdata/src/util.c - 3 int add(int a, int b)",
+		},
+	}, {
+		// The definition comes first, then the queried file, and
+		// then the other files. The declaration starts on the same
+		// line as its symbol, so it has no container.
+		name:   "symbol",
+		search: cscope.FindSymbol,
+		query:  "testdata/src/main.c:5:9",
+		want: []string{
+			"testdata/src/util.c - 3 int add(int a, int b)",
+			"testdata/src/main.c twice 5 \treturn add(x, x);",
+			"testdata/src/main.c main 10 \treturn add(1, twice(2));",
+			"testdata/src/util.h - 4 int add(int a, int b);",
+		},
+	}, {
+		name:   "callers",
+		search: cscope.FindCallers,
+		query:  "testdata/src/util.c:3:5",
+		want: []string{
+			"testdata/src/main.c twice 5 \treturn add(x, x);",
+			"testdata/src/main.c main 10 \treturn add(1, twice(2));",
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := replayRegistry(t)
+
+			opts := &searchOptions{
+				Order:     orderCscope,
+				Lines:     linecache.New(lineCacheSize),
+				CallDepth: 1,
+				Paths:     newPathMapper(reg.ws),
+			}
+
+			defer opts.Lines.Close()
+
+			results, err := search(reg, &cscope.Query{Search: tt.search, Pattern: tt.query}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range results {
+				got = append(got, r.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryPattern(t *testing.T) {
+	abs := func(path string) string {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	tests := []struct {
+		pattern string
+		want    *queryPosition
+	}{
+		{"main.c:10:5", &queryPosition{File: abs("main.c"), Line: 9, Col: 4}},
+		{"/src/main.c:1:1", &queryPosition{File: "/src/main.c", Line: 0, Col: 0}},
+		{"main.c:10:5@/tmp/buf", &queryPosition{File: abs("main.c"), Line: 9, Col: 4, Buffer: "/tmp/buf"}},
+		{"main.c:10:5#3", &queryPosition{File: abs("main.c"), Line: 9, Col: 4, Depth: 3}},
+		{"main.c:10:5#2@/tmp/buf", &queryPosition{File: abs("main.c"), Line: 9, Col: 4, Depth: 2, Buffer: "/tmp/buf"}},
+		{"main.c:10:5@/tmp/buf#2", &queryPosition{File: abs("main.c"), Line: 9, Col: 4, Buffer: "/tmp/buf#2"}},
+		{"c:/x.c:2:3", &queryPosition{File: abs("c:/x.c"), Line: 1, Col: 2}},
+		{"main.c", nil},
+		{"main.c:10", nil},
+		{"main.c:x:5", nil},
+		{"main.c:10:y", nil},
+		{"main.c:10:5#0", nil},
+		{"main.c:10:5#x", nil},
+		{"main.c:10:5#", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQueryPattern(tt.pattern)
+
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", tt.pattern, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", tt.pattern, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// fakeRegistry returns a registry whose C and C++ server is fake.
+func fakeRegistry(t *testing.T, fake *lsptest.Server) *registry {
+	t.Helper()
+
+	root, err := workspace.OpenRoot("testdata/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &workspace.Workspace{}
+	ws.Add(root)
+
+	reg := newRegistry(ws, []lsp.ServerOption{lsp.OptConn(fake.Dial)})
+	reg.register(&serverConfig{Path: "clangd"}, "c", "cpp")
+
+	t.Cleanup(reg.stop)
+
+	return reg
+}
+
+// fakeLocation returns the location of a range on one line of a file
+// in testdata/src. The line and columns are 0-based.
+func fakeLocation(t *testing.T, file string, line int, start int, end int) lsp.Location {
+	t.Helper()
+
+	path, err := filepath.Abs(filepath.Join("testdata/src", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lsp.Location{
+		URI: lsp.FileToURI(path),
+		Range: lsp.Range{
+			Start: lsp.Position{Line: line, Character: start},
+			End:   lsp.Position{Line: line, Character: end},
+		},
+	}
+}
+
+// fakeSymbol returns a symbol whose range covers the 0-based lines
+// start to end of a file in testdata/src. The container is omitted if
+// it is empty.
+func fakeSymbol(t *testing.T, name string, container string, kind lsp.SymbolKind, file string, start int, end int) lsp.SymbolInformation {
+	t.Helper()
+
+	sym := lsp.SymbolInformation{
+		Name:     name,
+		Kind:     int(kind),
+		Location: fakeLocation(t, file, start, 0, 0),
+	}
+
+	sym.Location.Range.End = lsp.Position{Line: end, Character: 1}
+
+	if container != "" {
+		sym.ContainerName = &container
+	}
+
+	return sym
+}
+
+func TestResolveContainerForLocation(t *testing.T) {
+	// cquery reports the full declaration of a symbol as its
+	// container name.
+	fake := lsptest.NewServer()
+	fake.DocumentSymbol(
+		fakeSymbol(t, "main", "", lsp.SymbolKindFunction, "main.c", 8, 11),
+		fakeSymbol(t, "twice", "static int twice(int x)", lsp.SymbolKindFunction, "main.c", 3, 6),
+		fakeSymbol(t, "count", "ns::count", lsp.SymbolKindVariable, "main.c", 0, 0),
+		fakeSymbol(t, "x", "int x", lsp.SymbolKindVariable, "main.c", 4, 5),
+	)
+
+	reg := fakeRegistry(t, fake)
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := []lsp.Location{
+		fakeLocation(t, "main.c", 4, 8, 11),
+		fakeLocation(t, "main.c", 9, 8, 11),
+		fakeLocation(t, "main.c", 0, 4, 9),
+		fakeLocation(t, "main.c", 5, 1, 2),
+		fakeLocation(t, "main.c", 7, 0, 1),
+	}
+
+	results := make([]cscope.Result, len(loc))
+	for i := range results {
+		results[i].Symbol = "-"
+	}
+
+	if _, err := resolveContainerForLocation(c.srv, results, loc); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Symbol)
+	}
+
+	// The symbol on line 0 starts on the same line as the location,
+	// so it doesn't contain it. The variable x has a container name
+	// with spaces, so its own name is used.
+	want := []string{"twice", "main", "-", "x", "-"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The symbols of each file are fetched once.
+	if n := len(fake.ReceivedMethod("textDocument/documentSymbol")); n != 1 {
+		t.Errorf("got %d documentSymbol requests, want 1", n)
+	}
+}
+
+func TestServe(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.Implementation(fakeLocation(t, "util.c", 2, 4, 7))
+	fake.CrashOn("textDocument/references")
+
+	reg := fakeRegistry(t, fake)
+
+	sess := &session{
+		reg: reg,
+		opts: &searchOptions{
+			Order:     orderCscope,
+			Lines:     linecache.New(lineCacheSize),
+			CallDepth: 1,
+			Paths:     newPathMapper(reg.ws),
+		},
+	}
+
+	defer sess.opts.Lines.Close()
+
+	// The symbol query crashes the server, which is restarted for
+	// the next query. Bad queries still get an empty result.
+	in := strings.Join([]string{
+		"1testdata/src/main.c:5:9",
+		"9testdata/src/main.c:5:9",
+		"1testdata/src/main.c",
+		"0testdata/src/main.c:5:9",
+		"1testdata/src/main.c:5:9",
+		"",
+	}, "\n")
+
+	var out bytes.Buffer
+
+	if err := serve(sess, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	def := "testdata/src/util.c - 3 int add(int a, int b)"
+
+	want := strings.Join([]string{
+		">> cscope: 1 lines",
+		def,
+		">> cscope: 0 lines",
+		">> cscope: 0 lines",
+		">> cscope: 0 lines",
+		">> cscope: 1 lines",
+		def,
+		">> ",
+	}, "\n")
+
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if n := len(fake.ReceivedMethod("initialize")); n != 2 {
+		t.Errorf("got %d initialize requests, want 2", n)
+	}
+}
+
+func TestSearchCallDepth(t *testing.T) {
+	item := func(name string, file string, line int) lsp.CallHierarchyItem {
+		loc := fakeLocation(t, file, line, 4, 4+len(name))
+
+		return lsp.CallHierarchyItem{
+			Name:           name,
+			Kind:           lsp.SymbolKindFunction,
+			URI:            loc.URI,
+			Range:          loc.Range,
+			SelectionRange: loc.Range,
+		}
+	}
+
+	from := func(name string, file string, line int, call int) lsp.CallHierarchyIncomingCall {
+		return lsp.CallHierarchyIncomingCall{
+			From:       item(name, file, line),
+			FromRanges: []lsp.Range{fakeLocation(t, file, call, 8, 11).Range},
+		}
+	}
+
+	fake := lsptest.NewServer()
+	fake.PrepareCallHierarchy(item("add", "util.c", 2))
+	fake.IncomingCalls("add", from("twice", "main.c", 2, 4), from("main", "main.c", 7, 9))
+	fake.IncomingCalls("twice", from("main", "main.c", 7, 9))
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{{
+		name:  "default",
+		query: "testdata/src/util.c:3:5",
+		want: []string{
+			"testdata/src/main.c twice 5 \treturn add(x, x);",
+			"testdata/src/main.c main 10 \treturn add(1, twice(2));",
+		},
+	}, {
+		// A deeper hierarchy is flattened depth first, and each
+		// result is prefixed by its level. The same line can
+		// appear at different levels.
+		name:  "depth",
+		query: "testdata/src/util.c:3:5#2",
+		want: []string{
+			"testdata/src/main.c twice 5 [1] \treturn add(x, x);",
+			"testdata/src/main.c main 10 [2] \treturn add(1, twice(2));",
+			"testdata/src/main.c main 10 [1] \treturn add(1, twice(2));",
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := fakeRegistry(t, fake)
+
+			opts := &searchOptions{
+				Order:     orderCscope,
+				Lines:     linecache.New(lineCacheSize),
+				CallDepth: 1,
+				Paths:     newPathMapper(reg.ws),
+			}
+
+			defer opts.Lines.Close()
+
+			results, err := search(reg, &cscope.Query{Search: cscope.FindCallers, Pattern: tt.query}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range results {
+				got = append(got, r.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSymbolDefinition(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		// The definition is found among the references, and the
+		// one line declaration in the header isn't taken to be a
+		// definition.
+		{"definition", "testdata/src/util.c:3:5", []string{
+			"testdata/src/util.c:3",
+			"testdata/src/main.c:5",
+			"testdata/src/main.c:10",
+			"testdata/src/util.h:4",
+		}},
+		{"reference", "testdata/src/main.c:5:9", []string{
+			"testdata/src/util.c:3",
+			"testdata/src/main.c:5",
+			"testdata/src/main.c:10",
+			"testdata/src/util.h:4",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := lsptest.NewServer()
+			fake.References(
+				fakeLocation(t, "main.c", 4, 8, 11),
+				fakeLocation(t, "main.c", 9, 8, 11),
+				fakeLocation(t, "util.c", 2, 4, 7),
+				fakeLocation(t, "util.h", 3, 4, 7),
+			)
+			fake.Definition(fakeLocation(t, "util.c", 2, 4, 7))
+			fake.DocumentSymbol(
+				fakeSymbol(t, "twice", "", lsp.SymbolKindFunction, "main.c", 2, 5),
+				fakeSymbol(t, "main", "", lsp.SymbolKindFunction, "main.c", 7, 10),
+				fakeSymbol(t, "add", "", lsp.SymbolKindFunction, "util.c", 2, 5),
+				fakeSymbol(t, "add", "", lsp.SymbolKindFunction, "util.h", 3, 3),
+			)
+
+			reg := fakeRegistry(t, fake)
+
+			opts := &searchOptions{
+				Order: orderCscope,
+				Lines: linecache.New(lineCacheSize),
+				Paths: newPathMapper(reg.ws),
+			}
+
+			defer opts.Lines.Close()
+
+			results, err := search(reg, &cscope.Query{Search: cscope.FindSymbol, Pattern: tt.query}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range results {
+				got = append(got, fmt.Sprintf("%s:%d", r.File, r.Line))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got results %q, want %q", got, tt.want)
+			}
+
+			if n := len(fake.ReceivedMethod("textDocument/definition")); n != 0 {
+				t.Errorf("got %d definition requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestPathMapperUnresolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Resolve dir itself, in case the temporary directory is behind a
+	// symlink.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	real := filepath.Join(dir, "real")
+
+	if err := os.MkdirAll(filepath.Join(real, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second root is inside the first, once the symlinks are
+	// resolved.
+	links := map[string]string{
+		"proj": real,
+		"lib":  filepath.Join(real, "sub"),
+	}
+
+	ws := &workspace.Workspace{}
+
+	for _, name := range []string{"proj", "lib"} {
+		link := filepath.Join(dir, name)
+
+		if err := os.Symlink(links[name], link); err != nil {
+			t.Fatal(err)
+		}
+
+		ws.Add(&workspace.Root{Dir: link})
+	}
+
+	m := &pathMapper{wd: dir, ws: ws}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(real, "main.c"), filepath.Join(dir, "proj/main.c")},
+		{filepath.Join(real, "sub/lib.c"), filepath.Join(dir, "lib/lib.c")},
+		{filepath.Join(real, "sub"), filepath.Join(dir, "lib")},
+		{filepath.Join(dir, "other.c"), ""},
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, tt := range tests {
+			if got := m.unresolve(tt.path); got != tt.want {
+				t.Fatalf("unresolve(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		}
+	}
+
+	if len(m.realDirs) != 2 {
+		t.Errorf("got %d resolved roots, want 2", len(m.realDirs))
+	}
+}
+
+// fakeRegistry returns a registry whose C and C++ server is fake.
+func TestPathMapperPath(t *testing.T) {
+	wd, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &workspace.Workspace{}
+	ws.Add(&workspace.Root{Dir: filepath.Join(wd, "testdata/src")})
+
+	m := &pathMapper{wd: wd, ws: ws}
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{lsp.FileToURI(filepath.Join(wd, "testdata/src/main.c")), "testdata/src/main.c"},
+		{"file://localhost" + filepath.ToSlash(filepath.Join(wd, "testdata/src/main.c")), "testdata/src/main.c"},
+		{"file://" + filepath.ToSlash(filepath.Join(wd, "testdata/src/my%20file.c")), "testdata/src/my file.c"},
+		{"file:///usr/include/stdio.h", "/usr/include/stdio.h"},
+		{"file://%zz/main.c", "file://%zz/main.c"},
+		{"file://host/src/main.c", "file://host/src/main.c"},
+		{"untitled:Untitled-1", "untitled:Untitled-1"},
+	}
+
+	for _, tt := range tests {
+		if got := m.path(tt.uri); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
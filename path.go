@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// defaultPathDepth is the greatest number of calls in the call
+	// chains that a path search finds, unless it is given a depth.
+	defaultPathDepth = 6
+
+	// defaultPathLimit is the number of call chains that a path
+	// search reports, unless it is given a limit.
+	defaultPathLimit = 10
+)
+
+// callChain is a sequence of calls, each made by the callee of the one
+// before.
+type callChain []*callEdge
+
+// findCallChains returns the chains of at most depth calls from any of
+// the from nodes to any of the to nodes, shortest first. No function
+// appears twice in a chain, except that the last may be the first. It
+// stops after limit chains, unless limit is 0.
+func findCallChains(g *callGraph, from []*callNode, to []*callNode, depth int, limit int) []callChain {
+	targets := map[string]bool{}
+	for _, n := range to {
+		targets[n.Key] = true
+	}
+
+	var chains []callChain
+
+	// Search for chains of each length in turn, so that the
+	// shortest are found first.
+	for length := 1; length <= depth; length++ {
+		var chain callChain
+
+		onChain := map[string]bool{}
+
+		// visit extends the chain from n, and returns false when
+		// there are enough chains.
+		var visit func(n *callNode) bool
+
+		visit = func(n *callNode) bool {
+			if len(chain) == length {
+				if !targets[n.Key] {
+					return true
+				}
+
+				chains = append(chains, append(callChain(nil), chain...))
+				return limit == 0 || len(chains) < limit
+			}
+
+			// A longer chain through a target would just
+			// extend a shorter chain.
+			if len(chain) > 0 && targets[n.Key] {
+				return true
+			}
+
+			onChain[n.Key] = true
+			defer delete(onChain, n.Key)
+
+			for _, e := range g.Callees(n) {
+				last := len(chain)+1 == length && targets[e.Callee.Key]
+				if onChain[e.Callee.Key] && !last {
+					continue
+				}
+
+				chain = append(chain, e)
+				more := visit(e.Callee)
+				chain = chain[:len(chain)-1]
+
+				if !more {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		for _, n := range from {
+			if !visit(n) {
+				return chains
+			}
+		}
+	}
+
+	return chains
+}
+
+// searchCallChains finds the chains of at most depth calls from the
+// function at one position to the function at another. It walks the
+// callees of the first function and the callers of the second, each
+// for half of the depth, and then joins the two halves, so that the
+// search grows with the depth half as fast as a walk from one end.
+func searchCallChains(reg *registry, paths *pathMapper, from *queryPosition, to *queryPosition, depth int, limit int) ([]callChain, error) {
+	c, fromCol, err := openPosition(reg, from)
+	if err != nil {
+		return nil, err
+	}
+
+	tc, toCol, err := openPosition(reg, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if tc != c {
+		return nil, fmt.Errorf("%s and %s use different language servers", from.File, to.File)
+	}
+
+	g := newCallGraph(c, paths)
+
+	sources, err := g.walk(from.File, from.Line, fromCol, false, (depth+1)/2)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := g.walk(to.File, to.Line, toCol, true, depth/2)
+	if err != nil {
+		return nil, err
+	}
+
+	return findCallChains(g, sources, targets, depth, limit), nil
+}
+
+// chainResults converts a call chain to results. The first result is
+// the first function, and the rest are the call sites, with the called
+// function as the Symbol.
+func chainResults(chain callChain) []cscope.Result {
+	first := chain[0].Caller
+
+	results := []cscope.Result{{
+		File:   first.File,
+		Line:   first.Line,
+		Symbol: first.Name,
+		Text:   "-",
+	}}
+
+	for _, e := range chain {
+		results = append(results, cscope.Result{
+			File:   e.File,
+			Line:   e.Lines[0],
+			Symbol: e.Callee.Name,
+			Text:   "-",
+		})
+	}
+
+	return results
+}
+
+// parsePathPositions parses the positions of the first and last
+// functions of a call path search. The depth of the search can only be
+// given on the first position.
+func parsePathPositions(first string, last string) (*queryPosition, *queryPosition, error) {
+	from, err := parseQueryPattern(first)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	to, err := parseQueryPattern(last)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if to.Depth > 0 {
+		return nil, nil, fmt.Errorf("depth on the last position '%s'", last)
+	}
+
+	return from, to, nil
+}
+
+// parsePathPattern parses the pattern of a call path query, which is
+// two query positions separated by whitespace. The depth of the search
+// is taken from the first position.
+func parsePathPattern(pattern string) (*queryPosition, *queryPosition, error) {
+	f := strings.Fields(pattern)
+	if len(f) != 2 {
+		return nil, nil, fmt.Errorf("invalid call path '%s'", pattern)
+	}
+
+	return parsePathPositions(f[0], f[1])
+}
+
+// searchCallPath answers a call path query. The results of each chain
+// follow each other, and the text of each result starts with the
+// number of its chain.
+func searchCallPath(reg *registry, paths *pathMapper, pattern string, opts *searchOptions) ([]cscope.Result, error) {
+	from, to, err := parsePathPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := defaultPathDepth
+	if from.Depth > 0 {
+		depth = from.Depth
+	}
+
+	chains, err := searchCallChains(reg, paths, from, to, depth, defaultPathLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []cscope.Result
+
+	for i, chain := range chains {
+		r := chainResults(chain)
+		resolveTextForResults(opts.Lines, paths.wd, r)
+
+		for j := range r {
+			r[j].Text = fmt.Sprintf("[%d] %s", i+1, r[j].Text)
+		}
+
+		results = append(results, r...)
+	}
+
+	return results, nil
+}
+
+// callPath implements the "path" subcommand, which prints the call
+// chains from one function to another.
+func callPath(args []string) error {
+	flags := pflag.NewFlagSet("path", pflag.ContinueOnError)
+	depth := flags.IntP("depth", "n", defaultPathDepth, "Greatest number of calls in a call chain")
+	limit := flags.Int("limit", defaultPathLimit, "Greatest number of call chains to print (0 prints all)")
+
+	flags.AddFlagSet(sessionFlags)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s path [OPTION...] FROM TO\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nPrints the call chains from the function at FROM to the function\n")
+		fmt.Fprintf(os.Stderr, "at TO, shortest first. Each position is \"file:line:col\", and FROM\n")
+		fmt.Fprintf(os.Stderr, "can end with \"#depth\" instead of giving --depth. Chains are\n")
+		fmt.Fprintf(os.Stderr, "separated by blank lines.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("missing function positions")
+	}
+
+	if *depth < 1 {
+		return fmt.Errorf("invalid depth %d", *depth)
+	}
+
+	from, to, err := parsePathPositions(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	// As in a call path query, the depth can be given on FROM.
+	if from.Depth > 0 {
+		if flags.Changed("depth") {
+			return fmt.Errorf("depth given by both --depth and '%s'", flags.Arg(0))
+		}
+
+		*depth = from.Depth
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.close()
+
+	paths := sess.opts.Paths
+
+	chains, err := searchCallChains(sess.reg, paths, from, to, *depth, *limit)
+	if err != nil {
+		return err
+	}
+
+	if len(chains) == 0 {
+		return fmt.Errorf("no call chain from %s to %s within %d calls", flags.Arg(0), flags.Arg(1), *depth)
+	}
+
+	for i, chain := range chains {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		r := chainResults(chain)
+		resolveTextForResults(sess.opts.Lines, paths.wd, r)
+
+		for _, res := range r {
+			fmt.Println(res)
+		}
+	}
+
+	return nil
+}
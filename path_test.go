@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindCallChains(t *testing.T) {
+	// main calls parse and run, which both call alloc. run also
+	// calls itself, and alloc calls back into run.
+	g := newCallGraph(nil, nil)
+
+	nodes := map[string]*callNode{}
+	for _, name := range []string{"main", "parse", "run", "alloc", "log"} {
+		nodes[name] = g.node(name, name, name+".c", 1)
+	}
+
+	for _, call := range []string{
+		"main parse", "main run", "parse alloc", "run alloc",
+		"run run", "alloc run", "main log",
+	} {
+		f := strings.Fields(call)
+		g.addCall(nodes[f[0]], nodes[f[1]], f[0]+".c", 2)
+	}
+
+	tests := []struct {
+		from  string
+		to    string
+		depth int
+		limit int
+		want  []string
+	}{
+		{"main", "alloc", 1, 0, nil},
+		{"main", "alloc", 2, 0, []string{"main parse alloc", "main run alloc"}},
+		{"main", "alloc", 2, 1, []string{"main parse alloc"}},
+		{"main", "alloc", 6, 0, []string{"main parse alloc", "main run alloc"}},
+		{"main", "log", 6, 0, []string{"main log"}},
+		{"parse", "run", 6, 0, []string{"parse alloc run"}},
+		{"run", "run", 6, 0, []string{"run run", "run alloc run"}},
+		{"log", "main", 6, 0, nil},
+	}
+
+	for _, tt := range tests {
+		chains := findCallChains(g, []*callNode{nodes[tt.from]}, []*callNode{nodes[tt.to]}, tt.depth, tt.limit)
+
+		var got []string
+		for _, c := range chains {
+			names := []string{c[0].Caller.Name}
+			for _, e := range c {
+				names = append(names, e.Callee.Name)
+			}
+
+			got = append(got, strings.Join(names, " "))
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s to %s within %d: got %q, want %q", tt.from, tt.to, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestParsePathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		depth   int
+		ok      bool
+	}{
+		{"main.c:10:5 util.c:3:5", 0, true},
+		{"main.c:10:5#4 util.c:3:5", 4, true},
+		{"  main.c:10:5#4\tutil.c:3:5 ", 4, true},
+		{"main.c:10:5 util.c:3:5#4", 0, false},
+		{"main.c:10:5", 0, false},
+		{"main.c:10:5 util.c:3:5 x.c:1:1", 0, false},
+		{"main.c:10 util.c:3:5", 0, false},
+	}
+
+	for _, tt := range tests {
+		from, to, err := parsePathPattern(tt.pattern)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%q: got %+v %+v, want an error", tt.pattern, from, to)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", tt.pattern, err)
+			continue
+		}
+
+		if from.Depth != tt.depth || to.Depth != 0 {
+			t.Errorf("%q: got depths %d and %d, want %d and 0", tt.pattern, from.Depth, to.Depth, tt.depth)
+		}
+	}
+}
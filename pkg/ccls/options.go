@@ -19,4 +19,7 @@ type CacheOptions struct {
 type InitializationOptions struct {
 	// Cache directory for indexed files.
 	Cache CacheOptions `json:"cache"`
+
+	// Directory containing compile_commands.json.
+	CompilationDatabaseDirectory string `json:"compilationDatabaseDirectory,omitempty"`
 }
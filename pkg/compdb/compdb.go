@@ -0,0 +1,255 @@
+package compdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileName is the conventional name of a compilation database.
+const FileName = "compile_commands.json"
+
+// Entry is a single compile command from a compilation database.
+//
+// See https://clang.llvm.org/docs/JSONCompilationDatabase.html
+type Entry struct {
+	// Directory is the working directory of the compilation.
+	Directory string `json:"directory"`
+
+	// File is the main translation unit source.
+	File string `json:"file"`
+
+	// Command is the compile command as a single shell-escaped
+	// string. Either Command or Arguments is present.
+	Command string `json:"command,omitempty"`
+
+	// Arguments is the compile command as a list of strings.
+	Arguments []string `json:"arguments,omitempty"`
+
+	// Output is the name of the output created by this compilation.
+	Output string `json:"output,omitempty"`
+}
+
+// Path returns the absolute path of the entry's source file.
+func (e *Entry) Path() string {
+	if filepath.IsAbs(e.File) {
+		return filepath.Clean(e.File)
+	}
+
+	return filepath.Join(e.Directory, e.File)
+}
+
+// Args returns the compile command as a list of arguments.
+func (e *Entry) Args() []string {
+	if len(e.Arguments) > 0 {
+		return e.Arguments
+	}
+
+	return splitCommand(e.Command)
+}
+
+// Language returns the LSP language identifier of the entry's
+// translation unit, based on the compiler driver, any explicit
+// "-x" language option and the source file extension.
+func (e *Entry) Language() string {
+	args := e.Args()
+
+	for i, a := range args {
+		var lang string
+
+		switch {
+		case a == "-x" && i+1 < len(args):
+			lang = args[i+1]
+		case strings.HasPrefix(a, "-x") && len(a) > 2:
+			lang = a[2:]
+		default:
+			continue
+		}
+
+		switch lang {
+		case "c", "c-header":
+			return "c"
+		case "c++", "c++-header":
+			return "cpp"
+		case "objective-c", "objective-c-header":
+			return "objective-c"
+		case "objective-c++", "objective-c++-header":
+			return "objective-cpp"
+		case "cuda":
+			return "cuda"
+		}
+	}
+
+	ext := filepath.Ext(e.File)
+
+	// By convention, upper case ".C" is a C++ source file.
+	if ext == ".C" {
+		return "cpp"
+	}
+
+	switch strings.ToLower(ext) {
+	case ".c":
+		return "c"
+	case ".m":
+		return "objective-c"
+	case ".mm":
+		return "objective-cpp"
+	case ".cu":
+		return "cuda"
+	case ".cc", ".cpp", ".cxx", ".c++", ".cp":
+		return "cpp"
+	}
+
+	if len(args) > 0 {
+		driver := filepath.Base(args[0])
+		if strings.Contains(driver, "++") {
+			return "cpp"
+		}
+	}
+
+	return ""
+}
+
+// Database is a loaded compilation database.
+type Database struct {
+	// Dir is the directory containing the database.
+	Dir string
+
+	// Entries are the compile commands in the database.
+	Entries []Entry
+
+	// languages counts the entry languages in each directory. It
+	// is built on first use, which may be from concurrent queries.
+	languages map[string]map[string]int
+	once      sync.Once
+}
+
+// Load reads the compilation database at path.
+func Load(path string) (*Database, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{}
+
+	if err := json.Unmarshal(data, &db.Entries); err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Dir = filepath.Dir(abs)
+
+	return db, nil
+}
+
+// HeaderLanguage guesses the language of a header file whose extension
+// is shared between languages, such as ".h". The guess is the most
+// common language of the translation units in the header's directory,
+// or in the nearest ancestor directory that has any translation units.
+// It returns an empty string if there is no basis for a guess.
+func (db *Database) HeaderLanguage(path string) string {
+	db.once.Do(db.countLanguages)
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if counts, ok := db.languages[dir]; ok {
+			best := ""
+			for lang, n := range counts {
+				if best == "" || n > counts[best] || (n == counts[best] && lang < best) {
+					best = lang
+				}
+			}
+
+			return best
+		}
+
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+// countLanguages counts the entry languages in each directory.
+func (db *Database) countLanguages() {
+	db.languages = map[string]map[string]int{}
+
+	for i := range db.Entries {
+		lang := db.Entries[i].Language()
+		if lang == "" {
+			continue
+		}
+
+		// Count each language in the entry's directory and every
+		// ancestor, so that a lookup finds the nearest directory
+		// with any entries.
+		for dir := filepath.Dir(db.Entries[i].Path()); ; dir = filepath.Dir(dir) {
+			if db.languages[dir] == nil {
+				db.languages[dir] = map[string]int{}
+			}
+
+			db.languages[dir][lang]++
+
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+}
+
+// splitCommand splits a shell-escaped command line into arguments.
+// It handles single and double quotes and backslash escapes, which is
+// what compilation database generators emit.
+func splitCommand(cmd string) []string {
+	var args []string
+	var arg strings.Builder
+
+	inArg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, r := range cmd {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
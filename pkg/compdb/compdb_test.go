@@ -0,0 +1,164 @@
+package compdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"", nil},
+		{"cc -c main.c", []string{"cc", "-c", "main.c"}},
+		{"  cc\t-c\nmain.c  ", []string{"cc", "-c", "main.c"}},
+		{`cc -DNAME="a b" main.c`, []string{"cc", "-DNAME=a b", "main.c"}},
+		{`cc '-DNAME="x"' main.c`, []string{"cc", `-DNAME="x"`, "main.c"}},
+		{`cc -DNAME=\"x\" main.c`, []string{"cc", `-DNAME="x"`, "main.c"}},
+		{`cc my\ file.c`, []string{"cc", "my file.c"}},
+		{`cc 'a\b' "c\"d"`, []string{"cc", `a\b`, `c"d`}},
+		{`cc "" main.c`, []string{"cc", "", "main.c"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCommand(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestEntryLanguage(t *testing.T) {
+	tests := []struct {
+		entry Entry
+		want  string
+	}{
+		{Entry{File: "main.c", Command: "cc -c main.c"}, "c"},
+		{Entry{File: "main.C", Command: "cc -c main.C"}, "cpp"},
+		{Entry{File: "main.cc", Arguments: []string{"cc", "-c", "main.cc"}}, "cpp"},
+		{Entry{File: "main.cxx", Command: "cc -c main.cxx"}, "cpp"},
+		{Entry{File: "main.m", Command: "cc -c main.m"}, "objective-c"},
+		{Entry{File: "main.mm", Command: "cc -c main.mm"}, "objective-cpp"},
+		{Entry{File: "kernel.cu", Command: "nvcc -c kernel.cu"}, "cuda"},
+		{Entry{File: "main.c", Command: "cc -x c++ -c main.c"}, "cpp"},
+		{Entry{File: "main.c", Command: "cc -xobjective-c -c main.c"}, "objective-c"},
+		{Entry{File: "x.h", Command: "cc -x c++-header x.h"}, "cpp"},
+		{Entry{File: "gen.inc", Command: "/usr/bin/clang++ -c gen.inc"}, "cpp"},
+		{Entry{File: "gen.inc", Command: "cc -c gen.inc"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.entry.Language(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.entry.Command, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	data := `[
+		{"directory": "/proj/build", "file": "../lib/a.c", "command": "cc -c ../lib/a.c"},
+		{"directory": "/proj/build", "file": "/proj/lib/b.c", "arguments": ["cc", "-c", "/proj/lib/b.c"]},
+		{"directory": "/proj", "file": "src/x.cc", "command": "c++ -c src/x.cc"},
+		{"directory": "/proj", "file": "src/y.cc", "command": "c++ -c src/y.cc"},
+		{"directory": "/proj", "file": "src/z.c", "command": "cc -c src/z.c"},
+		{"directory": "/proj", "file": "objc/v.m", "command": "cc -c objc/v.m"},
+		{"directory": "/proj", "file": "objc/w.mm", "command": "cc -c objc/w.mm"}
+	]`
+
+	path := filepath.Join(dir, FileName)
+
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Dir != dir {
+		t.Errorf("got directory %q, want %q", db.Dir, dir)
+	}
+
+	if len(db.Entries) != 7 {
+		t.Fatalf("got %d entries, want 7", len(db.Entries))
+	}
+
+	if got := db.Entries[0].Path(); got != "/proj/lib/a.c" {
+		t.Errorf("got path %q, want /proj/lib/a.c", got)
+	}
+
+	if got := db.Entries[1].Args(); !reflect.DeepEqual(got, []string{"cc", "-c", "/proj/lib/b.c"}) {
+		t.Errorf("got arguments %q", got)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/proj/lib/a.h", "c"},
+		{"/proj/src/x.h", "cpp"},
+		{"/proj/src/sub/dir/x.h", "cpp"},
+		// A tie goes to the language that sorts first.
+		{"/proj/objc/x.h", "objective-c"},
+		// The top directory has more C units than any other language.
+		{"/proj/include/x.h", "c"},
+		{"/other/x.h", "c"},
+	}
+
+	// Ask concurrently, since the languages are counted on first use.
+	var wg sync.WaitGroup
+
+	for _, tt := range tests {
+		wg.Add(1)
+
+		go func(path string, want string) {
+			defer wg.Done()
+
+			if got := db.HeaderLanguage(path); got != want {
+				t.Errorf("%s: got %q, want %q", path, got, want)
+			}
+		}(tt.path, tt.want)
+	}
+
+	wg.Wait()
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if _, err := Load(filepath.Join(dir, FileName)); err == nil {
+		t.Errorf("loading a missing database: got no error")
+	}
+
+	path := filepath.Join(dir, FileName)
+
+	if err := ioutil.WriteFile(path, []byte(`{"file": "x.c"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Errorf("loading an invalid database: got no error")
+	}
+
+	db := &Database{}
+	if got := db.HeaderLanguage("/proj/x.h"); got != "" {
+		t.Errorf("empty database: got %q, want no language", got)
+	}
+}
@@ -0,0 +1,241 @@
+// Package config loads per-project cscope-lsp configuration files.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileName is the name of the per-project configuration file.
+const FileName = ".cscope-lsp.json"
+
+// Duration is a time.Duration that is written in JSON as a string
+// such as "10s" or "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Server configures a language server.
+type Server struct {
+	// Languages are the LSP language IDs that the server handles.
+	Languages []string `json:"languages"`
+
+	// Path is the path to the server executable.
+	Path string `json:"path"`
+
+	// Args are additional arguments passed to the server.
+	Args []string `json:"args,omitempty"`
+
+	// Env holds additional environment variables for the server.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Backend selects server-specific behaviour, and is one of
+	// "clangd", "ccls" or "cquery". If it is empty, the backend is
+	// guessed from the server path.
+	Backend string `json:"backend,omitempty"`
+
+	// InitializationOptions are merged over the default
+	// initializationOptions for the backend.
+	InitializationOptions json.RawMessage `json:"initializationOptions,omitempty"`
+
+	// Launcher is a command prefix used to start the server, such
+	// as ["docker", "exec", "-i", "build"].
+	Launcher []string `json:"launcher,omitempty"`
+
+	// PathMappings map directories on the host to directories on
+	// the server's file system.
+	PathMappings []PathMapping `json:"pathMappings,omitempty"`
+}
+
+// PathMapping maps a directory on the host to the directory that a
+// language server sees, for example when it runs in a container.
+type PathMapping struct {
+	// Host is the directory on the host. A relative path is
+	// relative to the directory containing the configuration file.
+	Host string `json:"host"`
+
+	// Server is the directory on the server's file system.
+	Server string `json:"server"`
+}
+
+// Config is the contents of a configuration file.
+type Config struct {
+	// Servers configures the language servers. A server listed
+	// here replaces the default server for its languages.
+	Servers []Server `json:"servers,omitempty"`
+
+	// Languages maps file extensions to LSP language IDs.
+	Languages map[string]string `json:"languages,omitempty"`
+
+	// Timeout limits how long we wait for each LSP request.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Include lists glob patterns for the files that results may
+	// come from. If it is empty, results may come from any file.
+	Include []string `json:"include,omitempty"`
+
+	// Exclude lists glob patterns for files to omit from results.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Order is the result ordering: "cscope", "path" or "server".
+	Order string `json:"order,omitempty"`
+
+	// CallDepth is the number of levels of the call hierarchy that
+	// caller and callee queries search.
+	CallDepth int `json:"callDepth,omitempty"`
+
+	// Roots lists additional workspace roots. Relative paths are
+	// relative to the directory containing the configuration file.
+	Roots []string `json:"roots,omitempty"`
+
+	// Path is the file that the Config was loaded from.
+	Path string `json:"-"`
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for i, s := range cfg.Servers {
+		if s.Path == "" {
+			return nil, fmt.Errorf("%s: server %d has no path", path, i)
+		}
+
+		if len(s.Languages) == 0 {
+			return nil, fmt.Errorf("%s: server '%s' has no languages", path, s.Path)
+		}
+
+		for _, m := range s.PathMappings {
+			if m.Host == "" || m.Server == "" {
+				return nil, fmt.Errorf("%s: server '%s' has an incomplete path mapping", path, s.Path)
+			}
+		}
+	}
+
+	cfg.Path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range cfg.Roots {
+		if !filepath.IsAbs(r) {
+			cfg.Roots[i] = filepath.Join(filepath.Dir(cfg.Path), r)
+		}
+	}
+
+	for _, s := range cfg.Servers {
+		for i, m := range s.PathMappings {
+			if !filepath.IsAbs(m.Host) {
+				s.PathMappings[i].Host = filepath.Join(filepath.Dir(cfg.Path), m.Host)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Find looks for a configuration file in dir and each of its parent
+// directories, and returns the path of the first one it finds. It
+// returns an empty path if there is no configuration file.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, FileName)
+
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+
+		dir = parent
+	}
+}
+
+// Merge returns the JSON object in defaults with the fields in raw
+// merged over it. Nested objects are merged recursively, and all other
+// values in raw replace those in defaults.
+func Merge(defaults interface{}, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return defaults, nil
+	}
+
+	var base interface{}
+
+	if defaults != nil {
+		data, err := json.Marshal(defaults)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(data, &base); err != nil {
+			return nil, err
+		}
+	}
+
+	var over interface{}
+
+	if err := json.Unmarshal(raw, &over); err != nil {
+		return nil, err
+	}
+
+	return merge(base, over), nil
+}
+
+func merge(base interface{}, over interface{}) interface{} {
+	b, ok := base.(map[string]interface{})
+	if !ok {
+		return over
+	}
+
+	o, ok := over.(map[string]interface{})
+	if !ok {
+		return over
+	}
+
+	for k, v := range o {
+		b[k] = merge(b[k], v)
+	}
+
+	return b
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	type options struct {
+		Cache   string            `json:"cache"`
+		Index   map[string]int    `json:"index,omitempty"`
+		Flags   []string          `json:"flags,omitempty"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Enabled bool              `json:"enabled"`
+	}
+
+	tests := []struct {
+		name     string
+		defaults interface{}
+		raw      string
+		want     string
+	}{{
+		name:     "no overrides",
+		defaults: options{Cache: "/tmp"},
+		raw:      "",
+		want:     `{"cache":"/tmp","enabled":false}`,
+	}, {
+		name:     "replace field",
+		defaults: options{Cache: "/tmp"},
+		raw:      `{"cache": "/var/cache"}`,
+		want:     `{"cache":"/var/cache","enabled":false}`,
+	}, {
+		name:     "add field",
+		defaults: options{Cache: "/tmp"},
+		raw:      `{"extra": 1}`,
+		want:     `{"cache":"/tmp","enabled":false,"extra":1}`,
+	}, {
+		name:     "merge nested",
+		defaults: options{Index: map[string]int{"threads": 2, "depth": 1}},
+		raw:      `{"index": {"threads": 8}}`,
+		want:     `{"cache":"","enabled":false,"index":{"depth":1,"threads":8}}`,
+	}, {
+		name:     "replace array",
+		defaults: options{Flags: []string{"-a", "-b"}},
+		raw:      `{"flags": ["-c"]}`,
+		want:     `{"cache":"","enabled":false,"flags":["-c"]}`,
+	}, {
+		name:     "replace object with value",
+		defaults: options{Labels: map[string]string{"a": "b"}},
+		raw:      `{"labels": null}`,
+		want:     `{"cache":"","enabled":false,"labels":null}`,
+	}, {
+		name:     "nil defaults",
+		defaults: nil,
+		raw:      `{"a": {"b": 1}}`,
+		want:     `{"a":{"b":1}}`,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := Merge(tt.defaults, json.RawMessage(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.Marshal(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeInvalid(t *testing.T) {
+	if _, err := Merge(nil, json.RawMessage(`{"a":`)); err == nil {
+		t.Error("got no error for invalid JSON")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		data string
+		want *Config
+	}{{
+		name: "empty",
+		data: `{}`,
+		want: &Config{},
+	}, {
+		name: "full",
+		data: `{
+			"servers": [{
+				"languages": ["c", "cpp"],
+				"path": "clangd",
+				"env": {"A": "1"},
+				"launcher": ["docker", "exec", "-i", "build"],
+				"pathMappings": [
+					{"host": "src", "server": "/src"},
+					{"host": "/abs", "server": "/abs"}
+				]
+			}],
+			"languages": {"inc": "cpp"},
+			"timeout": "30s",
+			"include": ["src/**"],
+			"exclude": ["*.pb.h"],
+			"order": "path",
+			"callDepth": 2,
+			"roots": ["../lib", "/abs/root"]
+		}`,
+		want: &Config{
+			Servers: []Server{{
+				Languages: []string{"c", "cpp"},
+				Path:      "clangd",
+				Env:       map[string]string{"A": "1"},
+				Launcher:  []string{"docker", "exec", "-i", "build"},
+				PathMappings: []PathMapping{
+					{Host: filepath.Join(dir, "src"), Server: "/src"},
+					{Host: "/abs", Server: "/abs"},
+				},
+			}},
+			Languages: map[string]string{"inc": "cpp"},
+			Timeout:   Duration(30 * time.Second),
+			Include:   []string{"src/**"},
+			Exclude:   []string{"*.pb.h"},
+			Order:     "path",
+			CallDepth: 2,
+			Roots:     []string{filepath.Join(filepath.Dir(dir), "lib"), "/abs/root"},
+		},
+	}, {
+		name: "server without path",
+		data: `{"servers": [{"languages": ["c"]}]}`,
+	}, {
+		name: "server without languages",
+		data: `{"servers": [{"path": "clangd"}]}`,
+	}, {
+		name: "incomplete path mapping",
+		data: `{"servers": [{"path": "clangd", "languages": ["c"], "pathMappings": [{"host": "src"}]}]}`,
+	}, {
+		name: "invalid duration",
+		data: `{"timeout": 30}`,
+	}, {
+		name: "invalid JSON",
+		data: `{"order": }`,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, FileName)
+
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Load(path)
+
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.Path = path
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "a", FileName)
+	if err := ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{sub, filepath.Join(dir, "a")} {
+		got, err := Find(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != path {
+			t.Errorf("Find(%s): got %q, want %q", d, got, path)
+		}
+	}
+}
@@ -10,7 +10,7 @@ func Callers(s *lsp.Server, file string, line int, col int) ([]lsp.Location, err
 	var loc []lsp.Location
 	params := lsp.TextDocumentPositionParams{
 		TextDocument: lsp.TextDocumentIdentifier{
-			URI: lsp.FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: lsp.Position{
 			Line:      line,
@@ -22,18 +22,18 @@ func Callers(s *lsp.Server, file string, line int, col int) ([]lsp.Location, err
 		return nil, err
 	}
 
-	return loc, nil
+	return s.HostLocations(loc), nil
 }
 
-func CallerHierarchy(s *lsp.Server, file string, line int, col int) (*CallHierarchy, error) {
+func CallerHierarchy(s *lsp.Server, file string, line int, col int, levels int) (*CallHierarchy, error) {
 	var calls CallHierarchy
 
 	params := CallHierarchyParams{
 		Callee:       false,
-		Levels:       1,
+		Levels:       levels,
 		DetailedName: true,
 		TextDocument: lsp.TextDocumentIdentifier{
-			URI: lsp.FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: lsp.Position{
 			Line:      line,
@@ -45,18 +45,20 @@ func CallerHierarchy(s *lsp.Server, file string, line int, col int) (*CallHierar
 		return nil, err
 	}
 
+	hostCalls(s, &calls)
+
 	return &calls, nil
 }
 
-func CalleeHierarchy(s *lsp.Server, file string, line int, col int) (*CallHierarchy, error) {
+func CalleeHierarchy(s *lsp.Server, file string, line int, col int, levels int) (*CallHierarchy, error) {
 	var calls CallHierarchy
 
 	params := CallHierarchyParams{
 		Callee:       true,
-		Levels:       1,
+		Levels:       levels,
 		DetailedName: true,
 		TextDocument: lsp.TextDocumentIdentifier{
-			URI: lsp.FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: lsp.Position{
 			Line:      line,
@@ -68,5 +70,17 @@ func CalleeHierarchy(s *lsp.Server, file string, line int, col int) (*CallHierar
 		return nil, err
 	}
 
+	hostCalls(s, &calls)
+
 	return &calls, nil
 }
+
+// hostCalls maps the locations in a call hierarchy from the server to
+// our file system.
+func hostCalls(s *lsp.Server, calls *CallHierarchy) {
+	calls.Location.URI = s.HostURI(calls.Location.URI)
+
+	for i := range calls.Children {
+		hostCalls(s, &calls.Children[i])
+	}
+}
@@ -0,0 +1,269 @@
+package cscope
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Prompt is the prompt that a cscope line-oriented interface prints
+// when it is ready for a query.
+const Prompt = ">> "
+
+// ErrNoPrompt is returned when the server output ends before a
+// complete prompt.
+var ErrNoPrompt = errors.New("cscope: missing prompt")
+
+// Client is a client of the cscope line-oriented interface. It speaks
+// the protocol the same way that vim does (see if_cscope.c), so it can
+// be used to check that a server will work with vim.
+type Client struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// NewClient returns a Client that reads server output from r and writes
+// queries to w.
+func NewClient(r io.Reader, w io.Writer) *Client {
+	return &Client{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+}
+
+// ReadPrompt reads server output up to and including the next prompt.
+// Like vim, it discards any output that precedes the prompt.
+func (c *Client) ReadPrompt() error {
+	matched := 0
+
+	for matched < len(Prompt) {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			return ErrNoPrompt
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case b == Prompt[matched]:
+			matched++
+		case b == Prompt[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+
+	return nil
+}
+
+// readLine reads a line of server output, without the line terminator.
+func (c *Client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readCount reads the "cscope: N lines" result header. Like vim, it
+// ignores any output that doesn't look like the header.
+func (c *Client) readCount() (int, error) {
+	for {
+		line, err := c.readLine()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 3 || f[0] != "cscope:" || !strings.HasPrefix(f[2], "lines") {
+			continue
+		}
+
+		n, err := strconv.Atoi(f[1])
+		if err != nil {
+			continue
+		}
+
+		if n < 0 {
+			n = 0
+		}
+
+		return n, nil
+	}
+}
+
+// ParseResult parses a cscope result line. The file, function and
+// line number fields are separated by one or more spaces, and the
+// text is everything after the single space that follows the line
+// number.
+func ParseResult(line string) (Result, error) {
+	var fields []string
+
+	rest := line
+	for i := 0; i < 3; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return Result{}, fmt.Errorf("invalid result line '%s'", line)
+		}
+
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			end = len(rest)
+		}
+
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+
+	n, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid line number in '%s'", line)
+	}
+
+	return Result{
+		File:   fields[0],
+		Symbol: fields[1],
+		Line:   n,
+		Text:   strings.TrimPrefix(rest, " "),
+	}, nil
+}
+
+// Find sends a query and reads its results. The server must already
+// have printed a prompt (see ReadPrompt). Find reads the prompt that
+// follows the results, so it can be called repeatedly.
+func (c *Client) Find(search SearchType, pattern string) ([]Result, error) {
+	if _, err := fmt.Fprintf(c.w, "%d%s\n", int(search), pattern); err != nil {
+		return nil, err
+	}
+
+	n, err := c.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, n)
+
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := ParseResult(line)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	if err := c.ReadPrompt(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Quit asks the server to exit.
+func (c *Client) Quit() error {
+	_, err := io.WriteString(c.w, "q\n")
+	return err
+}
+
+// Process is a cscope-compatible program driven by a Client.
+type Process struct {
+	*Client
+
+	path string
+	args []string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+}
+
+// Start starts the program at path with the given arguments, and
+// waits for its first prompt. Vim starts cscope with the arguments
+// "-dl -f <database>".
+func Start(path string, args ...string) (*Process, error) {
+	p := &Process{
+		path: path,
+		args: args,
+	}
+
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Process) start() error {
+	var err error
+
+	p.cmd = exec.Command(p.path, p.args...)
+	p.cmd.Stderr = os.Stderr
+
+	p.in, err = p.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	out, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+
+	p.Client = NewClient(out, p.in)
+
+	if err := p.ReadPrompt(); err != nil {
+		p.kill()
+		return err
+	}
+
+	return nil
+}
+
+func (p *Process) kill() {
+	p.in.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// Reset kills and restarts the program, the same as vim's
+// ":cscope reset".
+func (p *Process) Reset() error {
+	p.kill()
+	return p.start()
+}
+
+// Close asks the program to quit and waits for it to exit.
+func (p *Process) Close() error {
+	p.Quit()
+	p.in.Close()
+
+	return p.cmd.Wait()
+}
@@ -0,0 +1,236 @@
+package cscope
+
+import (
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		line string
+		want *Result
+	}{
+		{"main.c main 10 return 0;", &Result{File: "main.c", Symbol: "main", Line: 10, Text: "return 0;"}},
+		{"main.c main 10 \treturn  x;", &Result{File: "main.c", Symbol: "main", Line: 10, Text: "\treturn  x;"}},
+		{"main.c  main   10  x", &Result{File: "main.c", Symbol: "main", Line: 10, Text: " x"}},
+		{"main.c - 3", &Result{File: "main.c", Symbol: "-", Line: 3}},
+		{"main.c - 3 ", &Result{File: "main.c", Symbol: "-", Line: 3}},
+		{"main.c - x text", nil},
+		{"main.c main", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseResult(tt.line)
+
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", tt.line, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", tt.line, err)
+			continue
+		}
+
+		if got != *tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.line, got, *tt.want)
+		}
+	}
+}
+
+// serveConn answers queries from the client end of a pipe with a
+// Conn, returning the given results for each query pattern. It
+// returns the queries it read.
+func serveConn(in io.Reader, out io.WriteCloser, results map[string][]Result) chan []Query {
+	queries := make(chan []Query, 1)
+
+	go func() {
+		defer out.Close()
+
+		var seen []Query
+		conn := Conn{In: in, Out: out}
+
+		for {
+			conn.Prompt()
+
+			q, err := conn.Read()
+			if err != nil {
+				queries <- seen
+				return
+			}
+
+			seen = append(seen, *q)
+			conn.Write(results[q.Pattern])
+		}
+	}()
+
+	return queries
+}
+
+func TestClientConn(t *testing.T) {
+	results := map[string][]Result{
+		"main": {
+			{File: "main.c", Symbol: "main", Line: 8, Text: "int main(void)"},
+			{File: "util.c", Symbol: "-", Line: 3, Text: "\tmain();"},
+		},
+	}
+
+	qr, qw := io.Pipe()
+	rr, rw := io.Pipe()
+
+	queries := serveConn(qr, rw, results)
+	c := NewClient(rr, qw)
+
+	if err := c.ReadPrompt(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Find(FindSymbol, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, results["main"]) {
+		t.Errorf("got %+v, want %+v", got, results["main"])
+	}
+
+	// The call path query has a two digit search type.
+	if _, err := c.Find(FindCallPath, "main.c:8 util.c:3"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = c.Find(FindCallers, "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("got %+v, want no results", got)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Query{
+		{Search: FindSymbol, Pattern: "main"},
+		{Search: FindCallPath, Pattern: "main.c:8 util.c:3"},
+		{Search: FindCallers, Pattern: "none"},
+	}
+
+	if got := <-queries; !reflect.DeepEqual(got, want) {
+		t.Errorf("server got %+v, want %+v", got, want)
+	}
+}
+
+func TestClientOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Result
+		err    bool
+	}{{
+		// Like vim, output before the prompt and the result
+		// header is ignored.
+		name:   "noise",
+		output: "starting\n>>> >> warning: slow\ncscope: x lines\ncscope: 1 lines\nmain.c main 8 int main(void)\n>> ",
+		want:   []Result{{File: "main.c", Symbol: "main", Line: 8, Text: "int main(void)"}},
+	}, {
+		name:   "crlf",
+		output: ">> cscope: 1 lines\r\nmain.c main 8 x\r\n>> ",
+		want:   []Result{{File: "main.c", Symbol: "main", Line: 8, Text: "x"}},
+	}, {
+		name:   "missing prompt",
+		output: ">> cscope: 0 lines\n> ",
+		err:    true,
+	}, {
+		name:   "missing results",
+		output: ">> cscope: 2 lines\nmain.c main 8 x\n",
+		err:    true,
+	}, {
+		name:   "missing header",
+		output: ">> main.c main 8 x\n",
+		err:    true,
+	}, {
+		name:   "bad result",
+		output: ">> cscope: 1 lines\nmain.c main\n>> ",
+		err:    true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries strings.Builder
+
+			c := NewClient(strings.NewReader(tt.output), &queries)
+
+			if err := c.ReadPrompt(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := c.Find(FindDefinition, "main.c:8:5")
+
+			if queries.String() != "1main.c:8:5\n" {
+				t.Errorf("sent %q, want %q", queries.String(), "1main.c:8:5\n")
+			}
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell")
+	}
+
+	// A fake cscope that answers one query per run with its
+	// arguments, so that Reset can be seen to restart it.
+	script := `printf '>> '; read q; printf 'cscope: 1 lines\nx.c - 1 %s %s\n>> ' "$q" "$*"; read q`
+
+	p, err := Start(sh, "-c", script, "cscope", "-dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			if err := p.Reset(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := p.Find(FindFile, "x.c")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Result{{File: "x.c", Symbol: "-", Line: 1, Text: "7x.c -dl"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 // SearchType specified the type of cscope search.
@@ -27,7 +28,11 @@ const (
 	// FindTextString Find this text string
 	FindTextString SearchType = 4
 
-	// Change this text string
+	// FindCallPath - Find call chains from one function to another.
+	// This is not a cscope search. cscope uses every digit, so it
+	// has the first number that cscope doesn't use, which is sent
+	// as the two digits "10".
+	FindCallPath SearchType = 10
 
 	// FindEgrepPattern - Find this egrep pattern
 	FindEgrepPattern SearchType = 6
@@ -39,6 +44,32 @@ const (
 	FindIncludingFiles SearchType = 8
 )
 
+// String returns a short name for the search type.
+func (s SearchType) String() string {
+	switch s {
+	case FindSymbol:
+		return "symbol"
+	case FindDefinition:
+		return "definition"
+	case FindCallees:
+		return "callees"
+	case FindCallers:
+		return "callers"
+	case FindTextString:
+		return "text"
+	case FindEgrepPattern:
+		return "egrep"
+	case FindFile:
+		return "file"
+	case FindIncludingFiles:
+		return "including"
+	case FindCallPath:
+		return "path"
+	default:
+		return fmt.Sprintf("search-%d", int(s))
+	}
+}
+
 // ErrQuit is a designated error returned when the Conn receives
 // a quit request.
 var ErrQuit = errors.New("quit")
@@ -57,6 +88,12 @@ type Result struct {
 	Text   string
 }
 
+// String formats the Result as a cscope output line, which is the file
+// name, function name, line number, and line text, separated by spaces.
+func (r Result) String() string {
+	return fmt.Sprintf("%s %s %d %s", r.File, r.Symbol, r.Line, r.Text)
+}
+
 // Conn is a connection from a cscope client.
 type Conn struct {
 	In  io.Reader
@@ -73,7 +110,9 @@ func (c *Conn) Prompt() error {
 
 // Read reads a cscope line query from the input. The line protocol is
 // very simple and consists of a digit (one of the SearchType constants),
-// followed by a pattern, followed by a newline.
+// followed by a pattern, followed by a newline. FindCallPath is the
+// only search type with two digits, so a query that starts with "10"
+// is a call path query.
 func (c *Conn) Read() (*Query, error) {
 	if c.scanner == nil {
 		c.scanner = bufio.NewScanner(c.In)
@@ -104,6 +143,13 @@ func (c *Conn) Read() (*Query, error) {
 		return nil, fmt.Errorf("unknown command '%s'", str)
 	}
 
+	pattern := str[1:]
+
+	if strings.HasPrefix(str, "10") {
+		n = int(FindCallPath)
+		pattern = str[2:]
+	}
+
 	switch SearchType(n) {
 	case FindSymbol:
 	case FindDefinition:
@@ -113,13 +159,14 @@ func (c *Conn) Read() (*Query, error) {
 	case FindEgrepPattern:
 	case FindFile:
 	case FindIncludingFiles:
+	case FindCallPath:
 	default:
 		return nil, fmt.Errorf("invalid search type %d", n)
 	}
 
 	return &Query{
 		Search:  SearchType(n),
-		Pattern: str[1:],
+		Pattern: pattern,
 	}, nil
 }
 
@@ -133,8 +180,7 @@ func (c *Conn) Write(results []Result) error {
 	}
 
 	for _, r := range results {
-		if _, err := c.Out.Write(
-			[]byte(fmt.Sprintf("%s %s %d %s\n", r.File, r.Symbol, r.Line, r.Text))); err != nil {
+		if _, err := io.WriteString(c.Out, r.String()+"\n"); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,211 @@
+// Package diff parses unified diffs, such as the output of "git diff",
+// to find the lines that a patch changes.
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// File is a file that a patch changes.
+type File struct {
+	// Path is the path of the file after the patch, with the
+	// leading path components removed.
+	Path string
+
+	// Lines holds the sorted 1-based lines of the file, after the
+	// patch, that were added or changed. A deletion is reported as
+	// a change to the line that follows it, or to the last line if
+	// nothing follows it.
+	Lines []int
+}
+
+// matchHunk matches a hunk header, capturing the start and length of
+// the old and new ranges, as in "@@ -10,7 +10,8 @@ func foo() {".
+var matchHunk = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// hunkCount returns a range length from a hunk header, which is 1 if
+// it is omitted.
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// stripPath removes the first strip components of path. It returns an
+// empty string if path has too few components.
+func stripPath(path string, strip int) string {
+	for i := 0; i < strip; i++ {
+		n := strings.IndexByte(path, '/')
+		if n < 0 {
+			return ""
+		}
+
+		path = path[n+1:]
+	}
+
+	return path
+}
+
+// newPath returns the path from a "+++" line, without any timestamp
+// that follows it.
+func newPath(line string) string {
+	path := strings.TrimPrefix(line, "+++ ")
+
+	if i := strings.IndexByte(path, '\t'); i >= 0 {
+		path = path[:i]
+	}
+
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		path = unquoted
+	}
+
+	return path
+}
+
+// Parse reads a unified diff and returns the files that it changes, in
+// the order that they appear. The first strip components of each path
+// are removed, like the -p option of patch, so 1 removes the "a/" and
+// "b/" prefixes that git adds. Deleted files are omitted.
+func Parse(r io.Reader, strip int) ([]File, error) {
+	var files []File
+
+	var cur *File
+
+	// changed holds the changed lines of the current file.
+	changed := map[int]bool{}
+
+	// line is the next line of the new file in the current hunk,
+	// and oldLeft and newLeft are the numbers of old and new lines
+	// left in the hunk.
+	var line, oldLeft, newLeft int
+
+	// deleted is true if lines were deleted before line, and
+	// empty is true if the hunk has no new lines, in which case
+	// its start is the line before the deletion.
+	var deleted, empty bool
+
+	// place reports a deletion as a change to the current line.
+	place := func() {
+		if deleted {
+			changed[line] = true
+			deleted = false
+		}
+	}
+
+	// endHunk reports a deletion at the end of a hunk, where no
+	// line follows it, as a change to the line before it.
+	endHunk := func() {
+		if !deleted {
+			return
+		}
+
+		l := line
+		if !empty {
+			l--
+		}
+
+		if l < 1 {
+			l = 1
+		}
+
+		changed[l] = true
+		deleted = false
+	}
+
+	finish := func() {
+		if cur == nil {
+			return
+		}
+
+		for l := range changed {
+			cur.Lines = append(cur.Lines, l)
+		}
+
+		sort.Ints(cur.Lines)
+
+		if cur.Path != "" && len(cur.Lines) > 0 {
+			files = append(files, *cur)
+		}
+
+		cur = nil
+		changed = map[int]bool{}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(text, "+"):
+				place()
+				changed[line] = true
+				line++
+				newLeft--
+
+			case strings.HasPrefix(text, "-"):
+				deleted = true
+				oldLeft--
+
+			case strings.HasPrefix(text, " "), text == "":
+				place()
+				line++
+				oldLeft--
+				newLeft--
+
+			case strings.HasPrefix(text, "\\"):
+				// "\ No newline at end of file"
+
+			default:
+				return nil, fmt.Errorf("invalid diff line %d", n)
+			}
+
+			if oldLeft <= 0 && newLeft <= 0 {
+				endHunk()
+			}
+
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			finish()
+
+			cur = &File{}
+
+			if path := newPath(text); path != "/dev/null" {
+				cur.Path = stripPath(path, strip)
+			}
+
+		case strings.HasPrefix(text, "@@"):
+			m := matchHunk.FindStringSubmatch(text)
+			if m == nil || cur == nil {
+				return nil, fmt.Errorf("invalid hunk header at line %d", n)
+			}
+
+			line, _ = strconv.Atoi(m[3])
+			oldLeft = hunkCount(m[2])
+			newLeft = hunkCount(m[4])
+			empty = newLeft == 0
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+
+	return files, nil
+}
@@ -0,0 +1,175 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		diff  string
+		strip int
+		want  []File
+	}{{
+		name: "change",
+		diff: `diff --git a/src/main.c b/src/main.c
+index 1111111..2222222 100644
+--- a/src/main.c
++++ b/src/main.c
+@@ -10,7 +10,7 @@ int main(void)
+ a
+ b
+ c
+-old
++new
+ d
+ e
+ f
+`,
+		strip: 1,
+		want:  []File{{Path: "src/main.c", Lines: []int{13}}},
+	}, {
+		name: "addition",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -1,2 +1,4 @@
+ a
++b
++c
+ d
+`,
+		strip: 1,
+		want:  []File{{Path: "x.c", Lines: []int{2, 3}}},
+	}, {
+		// A deletion is a change to the line that follows it.
+		name: "deletion",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -1,3 +1,2 @@
+ a
+-b
+ c
+`,
+		strip: 1,
+		want:  []File{{Path: "x.c", Lines: []int{2}}},
+	}, {
+		// A deletion at the end of a hunk is a change to the line
+		// before it.
+		name: "deletion at end",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -1,3 +1,2 @@
+ a
+ b
+-c
+`,
+		strip: 1,
+		want:  []File{{Path: "x.c", Lines: []int{2}}},
+	}, {
+		// A -U0 hunk with no new lines starts at the line before
+		// the deletion.
+		name: "no context deletion",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -5 +4,0 @@
+-gone
+@@ -8,0 +8,2 @@
++one
++two
+`,
+		strip: 1,
+		want:  []File{{Path: "x.c", Lines: []int{4, 8, 9}}},
+	}, {
+		// Added lines that look like headers are content.
+		name: "header lookalikes",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -1,1 +1,3 @@
+ a
++++x
++--- y
+`,
+		strip: 1,
+		want:  []File{{Path: "x.c", Lines: []int{2, 3}}},
+	}, {
+		name: "multiple files",
+		diff: `--- a/x.c
++++ b/x.c
+@@ -1 +1 @@
+-a
++b
+--- a/dir/y.c	2020-01-01 00:00:00
++++ b/dir/y.c	2020-01-01 00:00:01
+@@ -3,0 +4 @@
++c
+\ No newline at end of file
+`,
+		strip: 1,
+		want: []File{
+			{Path: "x.c", Lines: []int{1}},
+			{Path: "dir/y.c", Lines: []int{4}},
+		},
+	}, {
+		name: "deleted file",
+		diff: `--- a/x.c
++++ /dev/null
+@@ -1,2 +0,0 @@
+-a
+-b
+`,
+		strip: 1,
+		want:  nil,
+	}, {
+		name: "quoted path",
+		diff: `--- "a/with space.c"
++++ "b/with space.c"
+@@ -1 +1 @@
+-a
++b
+`,
+		strip: 1,
+		want:  []File{{Path: "with space.c", Lines: []int{1}}},
+	}, {
+		name: "no strip",
+		diff: `--- x.c
++++ x.c
+@@ -1 +1 @@
+-a
++b
+`,
+		strip: 0,
+		want:  []File{{Path: "x.c", Lines: []int{1}}},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.diff), tt.strip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		// A hunk before any file.
+		"@@ -1 +1 @@\n-a\n+b\n",
+		// A malformed hunk header.
+		"--- a/x.c\n+++ b/x.c\n@@ -1 @@\n",
+		// A hunk line without a prefix.
+		"--- a/x.c\n+++ b/x.c\n@@ -1,2 +1,2 @@\n a\n?b\n",
+	}
+
+	for _, diff := range tests {
+		if files, err := Parse(strings.NewReader(diff), 1); err == nil {
+			t.Errorf("%q: got %+v, want an error", diff, files)
+		}
+	}
+}
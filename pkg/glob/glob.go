@@ -0,0 +1,132 @@
+// Package glob matches paths against glob patterns in the syntax used
+// by the language server protocol.
+//
+// A "*" matches any run of characters within a path segment, "?"
+// matches a single character within a segment, and a "**" segment
+// matches any number of segments, including none. Braces group
+// alternatives, as in "**/*.{c,h}", and brackets match a range of
+// characters, as in "[0-9]" or "[!a-z]".
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Glob is a compiled glob pattern.
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Compile parses a glob pattern.
+func Compile(pattern string) (*Glob, error) {
+	var expr strings.Builder
+
+	expr.WriteString("^")
+
+	depth := 0
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch c {
+		case '*':
+			start := i == 0 || pattern[i-1] == '/'
+
+			if !strings.HasPrefix(pattern[i:], "**") {
+				expr.WriteString(`[^/]*`)
+				continue
+			}
+
+			i++
+
+			switch {
+			case start && i+1 < len(pattern) && pattern[i+1] == '/':
+				// A leading "**/" matches any number of
+				// directories, including none.
+				expr.WriteString(`(?:.*/)?`)
+				i++
+			case start && i+1 == len(pattern):
+				expr.WriteString(`.*`)
+			default:
+				// A "**" that is not a whole segment is
+				// the same as a "*".
+				expr.WriteString(`[^/]*`)
+			}
+
+		case '?':
+			expr.WriteString(`[^/]`)
+
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid glob '%s': unterminated '['", pattern)
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+
+		case '{':
+			expr.WriteString("(?:")
+			depth++
+
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("invalid glob '%s': unmatched '}'", pattern)
+			}
+
+			expr.WriteString(")")
+			depth--
+
+		case ',':
+			if depth > 0 {
+				expr.WriteString("|")
+			} else {
+				expr.WriteString(",")
+			}
+
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if depth > 0 {
+		return nil, fmt.Errorf("invalid glob '%s': unterminated '{'", pattern)
+	}
+
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob '%s': %s", pattern, err)
+	}
+
+	return &Glob{pattern: pattern, re: re}, nil
+}
+
+// MustCompile is like Compile, but panics if the pattern is invalid.
+func MustCompile(pattern string) *Glob {
+	g, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	return g
+}
+
+// Match returns true if path matches the pattern.
+func (g *Glob) Match(path string) bool {
+	return g.re.MatchString(path)
+}
+
+// String returns the pattern that g was compiled from.
+func (g *Glob) String() string {
+	return g.pattern
+}
@@ -0,0 +1,63 @@
+package glob
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.c", "main.c", true},
+		{"*.c", "src/main.c", false},
+		{"*.c", "main.h", false},
+		{"src/*.c", "src/main.c", true},
+		{"src/*.c", "src/sub/main.c", false},
+		{"?.c", "a.c", true},
+		{"?.c", "ab.c", false},
+		{"?.c", "/.c", false},
+		{"**/*.c", "main.c", true},
+		{"**/*.c", "src/sub/main.c", true},
+		{"src/**", "src/sub/main.c", true},
+		{"src/**", "src/", true},
+		{"src/**", "srcs/main.c", false},
+		{"src/**/*.h", "src/a.h", true},
+		{"src/**/*.h", "src/a/b/c.h", true},
+		{"a**b", "axyzb", true},
+		{"a**b", "ax/yb", false},
+		{"*.{c,h}", "main.c", true},
+		{"*.{c,h}", "main.h", true},
+		{"*.{c,h}", "main.cc", false},
+		{"{src,include}/**", "include/x.h", true},
+		{"a,b", "a,b", true},
+		{"[0-9].c", "7.c", true},
+		{"[0-9].c", "x.c", false},
+		{"[!0-9].c", "x.c", true},
+		{"[!0-9].c", "7.c", false},
+		{"*.pb.h", "foo.pb.h", true},
+		{"*.pb.h", "foopbxh", false},
+		{"third_party/**", "third_party/lib/x.c", true},
+	}
+
+	for _, tt := range tests {
+		g, err := Compile(tt.pattern)
+		if err != nil {
+			t.Errorf("%q: %s", tt.pattern, err)
+			continue
+		}
+
+		if got := g.Match(tt.path); got != tt.want {
+			t.Errorf("%q matching %q: got %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{"[abc", "{a,b", "a}", "{a,{b}"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("%q: got no error", pattern)
+		}
+	}
+}
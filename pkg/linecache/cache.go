@@ -0,0 +1,209 @@
+// Package linecache caches the lines of source files, so that the text
+// of search results can be shown without rereading each file for every
+// query.
+package linecache
+
+import (
+	"bytes"
+	"container/list"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// file is a memory mapped file and the offsets of the lines that we
+// have found so far.
+type file struct {
+	path    string
+	modTime time.Time
+	size    int64
+
+	// data is the file content. It is nil for an empty file.
+	data []byte
+
+	// lines holds the offset of the start of each line found so
+	// far. The lines are indexed lazily, since results are usually
+	// near the start of a file, or all in one part of it.
+	lines []int
+
+	// indexed is true when all the lines have been found.
+	indexed bool
+}
+
+func openFile(path string, st os.FileInfo) (*file, error) {
+	f := &file{
+		path:    path,
+		modTime: st.ModTime(),
+		size:    st.Size(),
+		lines:   []int{0},
+	}
+
+	// A zero length mapping is an error, and there is nothing to
+	// map anyway.
+	if st.Size() == 0 {
+		f.indexed = true
+		return f, nil
+	}
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unix.Close(fd)
+
+	f.data, err = unix.Mmap(fd, 0, int(st.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *file) close() {
+	if f.data != nil {
+		unix.Munmap(f.data)
+		f.data = nil
+	}
+}
+
+// line returns the text of the 0-based line n, without the line
+// terminator.
+func (f *file) line(n int) (text string, ok bool) {
+	// If the file is truncated after we map it, touching the
+	// missing pages faults. Turn that into a panic, and report
+	// the line as missing.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+
+	defer func() {
+		if recover() != nil {
+			text, ok = "", false
+		}
+	}()
+
+	for !f.indexed && len(f.lines) <= n+1 {
+		start := f.lines[len(f.lines)-1]
+
+		i := bytes.IndexByte(f.data[start:], '\n')
+		if i < 0 {
+			f.indexed = true
+			break
+		}
+
+		f.lines = append(f.lines, start+i+1)
+	}
+
+	if n < 0 || n >= len(f.lines) {
+		return "", false
+	}
+
+	start := f.lines[n]
+	end := len(f.data)
+
+	if n+1 < len(f.lines) {
+		end = f.lines[n+1] - 1
+	}
+
+	// The last line of a file that ends with a newline is empty,
+	// and isn't really a line.
+	if start == len(f.data) && n > 0 {
+		return "", false
+	}
+
+	return strings.TrimSuffix(string(f.data[start:end]), "\r"), true
+}
+
+// Cache holds the lines of a bounded number of recently used files.
+// A file is reloaded when its modification time or size changes. It
+// is safe for concurrent use.
+type Cache struct {
+	lock  sync.Mutex
+	limit int
+
+	// lru holds *file values, most recently used at the front.
+	lru   *list.List
+	files map[string]*list.Element
+}
+
+// New returns a Cache that holds at most limit files.
+func New(limit int) *Cache {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &Cache{
+		limit: limit,
+		lru:   list.New(),
+		files: map[string]*list.Element{},
+	}
+}
+
+// get returns the current content of the file at path. The lock must
+// be held.
+func (c *Cache) get(path string) (*file, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := c.files[path]; ok {
+		f := e.Value.(*file)
+		if f.modTime.Equal(st.ModTime()) && f.size == st.Size() {
+			c.lru.MoveToFront(e)
+			return f, nil
+		}
+
+		c.remove(e)
+	}
+
+	f, err := openFile(path, st)
+	if err != nil {
+		return nil, err
+	}
+
+	c.files[path] = c.lru.PushFront(f)
+
+	for c.lru.Len() > c.limit {
+		c.remove(c.lru.Back())
+	}
+
+	return f, nil
+}
+
+func (c *Cache) remove(e *list.Element) {
+	f := e.Value.(*file)
+
+	c.lru.Remove(e)
+	delete(c.files, f.path)
+	f.close()
+}
+
+// Line returns the text of the 0-based line n of the file at path,
+// without the line terminator. It returns false if the file has no
+// such line.
+func (c *Cache) Line(path string, n int) (string, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	f, err := c.get(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	text, ok := f.line(n)
+	return text, ok, nil
+}
+
+// Close releases all the cached files.
+func (c *Cache) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Front())
+	}
+}
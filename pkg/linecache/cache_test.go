@@ -0,0 +1,133 @@
+package linecache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"empty", "", []string{""}},
+		{"one line", "one\n", []string{"one"}},
+		{"no final newline", "one\ntwo", []string{"one", "two"}},
+		{"blank lines", "\n\nthree\n", []string{"", "", "three"}},
+		{"crlf", "one\r\ntwo\r\n", []string{"one", "two"}},
+		{"utf-8", "héllo\n世界\n", []string{"héllo", "世界"}},
+	}
+
+	c := New(2)
+	defer c.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Ask for the lines backwards, so that the first
+			// lookup indexes the whole file.
+			for n := len(tt.want) - 1; n >= 0; n-- {
+				text, ok, err := c.Line(path, n)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !ok || text != tt.want[n] {
+					t.Errorf("line %d: got %q, %t, want %q", n, text, ok, tt.want[n])
+				}
+			}
+
+			for _, n := range []int{-1, len(tt.want)} {
+				if text, ok, _ := c.Line(path, n); ok {
+					t.Errorf("line %d: got %q, want no line", n, text)
+				}
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+
+	c := New(1)
+	defer c.Close()
+
+	write := func(data string, mtime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(n int, want string) {
+		t.Helper()
+
+		text, ok, err := c.Line(path, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ok || text != want {
+			t.Errorf("line %d: got %q, %t, want %q", n, text, ok, want)
+		}
+	}
+
+	now := time.Now()
+
+	write("one\ntwo\n", now)
+	check(1, "two")
+
+	// A change of size is noticed even if the time is the same.
+	write("one\nthree\n", now)
+	check(1, "three")
+
+	// A change of time is noticed even if the size is the same.
+	write("one\nfour!\n", now.Add(time.Second))
+	check(1, "four!")
+
+	// Evicting the file and reading it again finds it.
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if text, _, _ := c.Line(other, 0); text != "x" {
+		t.Errorf("other: got %q, want %q", text, "x")
+	}
+
+	check(0, "one")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := c.Line(path, 0); err == nil {
+		t.Error("got no error for a removed file")
+	}
+}
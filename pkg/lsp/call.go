@@ -2,16 +2,16 @@ package lsp
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// FileToURI ...
+// FileToURI returns the "file" URI for path. Relative paths are made
+// absolute, and characters that are not allowed in a URI path are
+// percent-encoded.
 func FileToURI(path string) string {
 	// If this is already a URL, leave it alone.
 	if strings.HasPrefix(path, "file://") {
@@ -20,63 +20,129 @@ func FileToURI(path string) string {
 
 	// If we can't convert to an absolute path, just keep it
 	// and hope for the best.
-	abs, err := filepath.Abs(path)
-	if err != nil {
-		return fmt.Sprintf("file://%s", path)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(path),
 	}
 
-	return fmt.Sprintf("file://%s", abs)
+	return u.String()
 }
 
-// FileToLanguageID maps a file extension to a TextDocumentItem
-// identifier. Currently this handles only C++ files.
-func FileToLanguageID(path string) string {
-	ident := map[string]string{
-		"cpp": "cpp",
-		"cc":  "cpp",
-		"c++": "cpp",
-		"hpp": "cpp",
+// URIToPath returns the file system path for a "file" URI, decoding
+// any percent-encoded characters. It returns an error for URIs with
+// other schemes, which have no path that we can use.
+func URIToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
 
-		"c": "c",
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI scheme '%s'", u.Scheme)
 	}
 
-	ext := strings.ToLower(filepath.Ext(path))
+	// A "file" URI may name the local host, as in
+	// "file://localhost/path", which is the same as no host.
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("unsupported URI host '%s'", u.Host)
+	}
 
-	return ident[ext]
+	if u.Path == "" {
+		return "", fmt.Errorf("empty path in URI '%s'", uri)
+	}
+
+	return filepath.FromSlash(u.Path), nil
 }
 
-// Initialize ...
-func Initialize(s *Server, path string, options interface{}) error {
-	var res json.RawMessage
+// workspaceFolders returns the WorkspaceFolders for the given
+// directories.
+func workspaceFolders(s *Server, dirs []string) ([]WorkspaceFolder, error) {
+	folders := make([]WorkspaceFolder, 0, len(dirs))
 
-	if !filepath.IsAbs(path) {
-		abs, err := filepath.Abs(path)
+	for _, d := range dirs {
+		abs, err := filepath.Abs(d)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		path = abs
+		folders = append(folders, WorkspaceFolder{
+			URI:  s.ServerURI(abs),
+			Name: filepath.Base(abs),
+		})
+	}
+
+	return folders, nil
+}
+
+// Initialize initializes the server with the given workspace roots.
+// The first root is also sent as the rootUri, for servers that do
+// not support workspace folders.
+func Initialize(s *Server, roots []string, options interface{}) error {
+	var res InitializeResult
+
+	if len(roots) == 0 {
+		return fmt.Errorf("no workspace roots")
 	}
 
-	pathURL := fmt.Sprintf("file://%s", path)
+	folders, err := workspaceFolders(s, roots)
+	if err != nil {
+		return err
+	}
+
+	caps := ClientCapabilities{
+		Workspace: &WorkspaceClientCapabilities{
+			WorkspaceFolders: true,
+		},
+		Window: &WindowClientCapabilities{
+			WorkDoneProgress: true,
+		},
+		General: &GeneralClientCapabilities{
+			PositionEncodings: positionEncodings,
+		},
+		OffsetEncoding: positionEncodings,
+	}
+
+	if w := s.fileWatcher(); w != nil {
+		w.addRoots(roots)
+
+		caps.Workspace.DidChangeWatchedFiles = &DidChangeWatchedFilesClientCapabilities{
+			DynamicRegistration: true,
+		}
+	}
 
-	err := s.Call(
+	err = s.Call(
 		context.Background(),
 		"initialize",
 		&InitializeParams{
-			ProcessID: os.Getpid(),
-			RootURI:   pathURL,
-			Trace:     TraceMessages,
-			WorkspaceFolders: []WorkspaceFolder{
-				{
-					URI: pathURL,
-				},
-			},
+			ProcessID:             os.Getpid(),
+			RootURI:               folders[0].URI,
+			Trace:                 TraceMessages,
+			Capabilities:          caps,
+			WorkspaceFolders:      folders,
 			InitializationOptions: options,
 		},
 		&res)
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.Capabilities.PositionEncoding != "":
+		s.setPositionEncoding(res.Capabilities.PositionEncoding)
+	case res.OffsetEncoding != "":
+		s.setPositionEncoding(res.OffsetEncoding)
+	default:
+		s.setPositionEncoding(PositionEncodingUTF16)
+	}
+
+	// Servers wait for this before sending their own requests,
+	// such as capability registrations.
+	return s.Notify(context.Background(), "initialized", struct{}{})
 }
 
 // TextDocumentDefinition returns one or more Locations for the definition of
@@ -88,7 +154,7 @@ func TextDocumentDefinition(s *Server, file string, line int, col int) ([]Locati
 
 	pos := TextDocumentPositionParams{
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: Position{
 			Line:      line,
@@ -100,7 +166,7 @@ func TextDocumentDefinition(s *Server, file string, line int, col int) ([]Locati
 		return nil, err
 	}
 
-	return loc, nil
+	return s.HostLocations(loc), nil
 }
 
 // TextDocumentImplementation resolves the implementation location
@@ -110,7 +176,7 @@ func TextDocumentImplementation(s *Server, file string, line int, col int) ([]Lo
 
 	pos := TextDocumentPositionParams{
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: Position{
 			Line:      line,
@@ -122,7 +188,7 @@ func TextDocumentImplementation(s *Server, file string, line int, col int) ([]Lo
 		return nil, err
 	}
 
-	return loc, nil
+	return s.HostLocations(loc), nil
 }
 
 // TextDocumentTypeDefinition resolve the type definition location
@@ -132,7 +198,7 @@ func TextDocumentTypeDefinition(s *Server, file string, line int, col int) ([]Lo
 
 	pos := TextDocumentPositionParams{
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: Position{
 			Line:      line,
@@ -144,19 +210,21 @@ func TextDocumentTypeDefinition(s *Server, file string, line int, col int) ([]Lo
 		return nil, err
 	}
 
-	return loc, nil
+	return s.HostLocations(loc), nil
 }
 
-// TextDocumentReferences ...
-func TextDocumentReferences(s *Server, file string, line int, col int) ([]Location, error) {
+// TextDocumentReferences returns the references to the symbol at the
+// given document position. If includeDeclaration is true, the
+// declaration of the symbol is one of the references.
+func TextDocumentReferences(s *Server, file string, line int, col int, includeDeclaration bool) ([]Location, error) {
 	var loc []Location
 
 	ref := ReferenceParams{
 		Context: ReferenceContext{
-			IncludeDeclaration: true,
+			IncludeDeclaration: includeDeclaration,
 		},
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(file),
+			URI: s.ServerURI(file),
 		},
 		Position: Position{
 			Line:      line,
@@ -168,38 +236,64 @@ func TextDocumentReferences(s *Server, file string, line int, col int) ([]Locati
 		return nil, err
 	}
 
-	return loc, nil
+	return s.HostLocations(loc), nil
 }
 
-// TextDocumentDidOpen ...
-func TextDocumentDidOpen(s *Server, path string, vers int) error {
-	u, err := url.Parse(path)
-	if err != nil {
+// WorkspaceDidChangeWorkspaceFolders tells the server that workspace
+// roots were added or removed.
+func WorkspaceDidChangeWorkspaceFolders(s *Server, added []string, removed []string) error {
+	var params DidChangeWorkspaceFoldersParams
+	var err error
+
+	if params.Event.Added, err = workspaceFolders(s, added); err != nil {
 		return err
 	}
 
-	text, err := ioutil.ReadFile(u.Path)
-	if err != nil {
+	if params.Event.Removed, err = workspaceFolders(s, removed); err != nil {
 		return err
 	}
 
+	if w := s.fileWatcher(); w != nil {
+		w.addRoots(added)
+	}
+
+	return s.Notify(context.Background(), "workspace/didChangeWorkspaceFolders", &params)
+}
+
+// TextDocumentDidOpen ...
+func TextDocumentDidOpen(s *Server, path string, vers int, text string) error {
 	params := DidOpenTextDocumentParams{
 		TextDocument: TextDocumentItem{
 			LanguageID: FileToLanguageID(path),
-			URI:        FileToURI(path),
+			URI:        s.ServerURI(path),
 			Version:    vers,
-			Text:       string(text),
+			Text:       text,
 		},
 	}
 
 	return s.Notify(context.Background(), "textDocument/didOpen", &params)
 }
 
+// TextDocumentDidChange replaces the full text of an open document.
+func TextDocumentDidChange(s *Server, path string, vers int, text string) error {
+	params := DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			URI:     s.ServerURI(path),
+			Version: vers,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{
+			{Text: text},
+		},
+	}
+
+	return s.Notify(context.Background(), "textDocument/didChange", &params)
+}
+
 // TextDocumentDidClose ...
 func TextDocumentDidClose(s *Server, path string) error {
 	params := DidCloseTextDocumentParams{
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(path),
+			URI: s.ServerURI(path),
 		},
 	}
 
@@ -212,7 +306,7 @@ func TextDocumentDocumentSymbol(s *Server, path string) ([]SymbolInformation, er
 
 	params := DocumentSymbolParams{
 		TextDocument: TextDocumentIdentifier{
-			URI: FileToURI(path),
+			URI: s.ServerURI(path),
 		},
 	}
 
@@ -220,5 +314,105 @@ func TextDocumentDocumentSymbol(s *Server, path string) ([]SymbolInformation, er
 		return nil, err
 	}
 
+	m := s.pathMap()
+
+	for i := range syms {
+		syms[i].Location.URI = m.hostURI(syms[i].Location.URI)
+	}
+
+	return syms, nil
+}
+
+// WorkspaceSymbol returns the symbols in the workspace that match the
+// query.
+func WorkspaceSymbol(s *Server, query string) ([]SymbolInformation, error) {
+	var syms []SymbolInformation
+
+	params := WorkspaceSymbolParams{
+		Query: query,
+	}
+
+	if err := s.Call(context.Background(), "workspace/symbol", &params, &syms); err != nil {
+		return nil, err
+	}
+
+	m := s.pathMap()
+
+	for i := range syms {
+		syms[i].Location.URI = m.hostURI(syms[i].Location.URI)
+	}
+
 	return syms, nil
 }
+
+// TextDocumentPrepareCallHierarchy returns the call hierarchy items
+// for the symbol at the given document position.
+func TextDocumentPrepareCallHierarchy(s *Server, file string, line int, col int) ([]CallHierarchyItem, error) {
+	var items []CallHierarchyItem
+
+	pos := TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{
+			URI: s.ServerURI(file),
+		},
+		Position: Position{
+			Line:      line,
+			Character: col,
+		},
+	}
+
+	if err := s.Call(context.Background(), "textDocument/prepareCallHierarchy", pos, &items); err != nil {
+		return nil, err
+	}
+
+	m := s.pathMap()
+
+	for i := range items {
+		items[i].URI = m.hostURI(items[i].URI)
+	}
+
+	return items, nil
+}
+
+// CallHierarchyIncomingCalls returns the calls to a call hierarchy
+// item.
+func CallHierarchyIncomingCalls(s *Server, item CallHierarchyItem) ([]CallHierarchyIncomingCall, error) {
+	var calls []CallHierarchyIncomingCall
+
+	m := s.pathMap()
+
+	item.URI = m.serverURI(item.URI)
+
+	err := s.Call(context.Background(), "callHierarchy/incomingCalls",
+		CallHierarchyCallsParams{Item: item}, &calls)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range calls {
+		calls[i].From.URI = m.hostURI(calls[i].From.URI)
+	}
+
+	return calls, nil
+}
+
+// CallHierarchyOutgoingCalls returns the calls made by a call hierarchy
+// item.
+func CallHierarchyOutgoingCalls(s *Server, item CallHierarchyItem) ([]CallHierarchyOutgoingCall, error) {
+	var calls []CallHierarchyOutgoingCall
+
+	m := s.pathMap()
+
+	item.URI = m.serverURI(item.URI)
+
+	err := s.Call(context.Background(), "callHierarchy/outgoingCalls",
+		CallHierarchyCallsParams{Item: item}, &calls)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range calls {
+		calls[i].To.URI = m.hostURI(calls[i].To.URI)
+	}
+
+	return calls, nil
+}
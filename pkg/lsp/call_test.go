@@ -0,0 +1,45 @@
+package lsp
+
+import (
+	"testing"
+)
+
+func TestURIToPath(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"file:///src/main.c", "/src/main.c"},
+		{"file:///src/my%20file.c", "/src/my file.c"},
+		{"file:///src/c%2B%2B/vector", "/src/c++/vector"},
+		{"file://localhost/src/main.c", "/src/main.c"},
+		{"file:///src/main.c?x#y", "/src/main.c"},
+		{"file://%zz/main.c", ""},
+		{"file:///src/%zz.c", ""},
+		{"file://host/src/main.c", ""},
+		{"untitled:Untitled-1", ""},
+		{"https://example.com/main.c", ""},
+		{"file://", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := URIToPath(tt.uri)
+
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tt.uri, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", tt.uri, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
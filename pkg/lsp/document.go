@@ -0,0 +1,198 @@
+package lsp
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Document is a text document that is open in the language server.
+type Document struct {
+	// Path is the absolute path of the document.
+	Path string
+
+	// Version is the version number last sent to the server.
+	Version int
+
+	// Text is the document content last sent to the server.
+	Text string
+
+	modTime time.Time
+	size    int64
+}
+
+// Line returns the text of the given 0-based line, without the line
+// terminator. It returns an empty string if the line is out of range.
+func (d *Document) Line(n int) string {
+	text := d.Text
+
+	for ; n > 0; n-- {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			return ""
+		}
+		text = text[i+1:]
+	}
+
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+
+	return strings.TrimSuffix(text, "\r")
+}
+
+// DocumentManager keeps a bounded set of recently used documents open
+// in the language server. Keeping documents open saves the server from
+// reparsing a file each time we query it. When the number of open
+// documents exceeds the limit, the least recently used document is
+// closed.
+type DocumentManager struct {
+	srv   *Server
+	limit int
+
+	// lru holds *Document values, most recently used at the front.
+	lru  *list.List
+	docs map[string]*list.Element
+}
+
+// NewDocumentManager returns a DocumentManager that keeps at most
+// limit documents open in the given Server.
+func NewDocumentManager(s *Server, limit int) *DocumentManager {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &DocumentManager{
+		srv:   s,
+		limit: limit,
+		lru:   list.New(),
+		docs:  map[string]*list.Element{},
+	}
+}
+
+// Open ensures that the document at path is open in the server and
+// that the server has its current on-disk content. If the file has
+// changed since it was last sent, the server is given the new full
+// text in a "textDocument/didChange" notification.
+func (m *DocumentManager) Open(path string) (*Document, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := m.docs[path]; ok {
+		doc := e.Value.(*Document)
+		if doc.modTime.Equal(st.ModTime()) && doc.size == st.Size() {
+			m.lru.MoveToFront(e)
+			return doc, nil
+		}
+	}
+
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := m.sync(path, string(text))
+	if err != nil {
+		return nil, err
+	}
+
+	doc.modTime = st.ModTime()
+	doc.size = st.Size()
+
+	return doc, nil
+}
+
+// Update sends text as the content of the document at path, rather
+// than the on-disk content. This lets the server see unsaved editor
+// buffers. A subsequent Open will restore the on-disk content.
+func (m *DocumentManager) Update(path string, text string) (*Document, error) {
+	doc, err := m.sync(path, text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clear the file state so that the next Open resends the
+	// on-disk content.
+	doc.modTime = time.Time{}
+	doc.size = 0
+
+	return doc, nil
+}
+
+// sync sends text as the content of the document at path, opening
+// the document if necessary.
+func (m *DocumentManager) sync(path string, text string) (*Document, error) {
+	if e, ok := m.docs[path]; ok {
+		doc := e.Value.(*Document)
+		m.lru.MoveToFront(e)
+
+		if doc.Text == text {
+			return doc, nil
+		}
+
+		if err := TextDocumentDidChange(m.srv, path, doc.Version+1, text); err != nil {
+			return nil, err
+		}
+
+		doc.Version++
+		doc.Text = text
+		return doc, nil
+	}
+
+	doc := &Document{
+		Path:    path,
+		Version: 1,
+		Text:    text,
+	}
+
+	if err := TextDocumentDidOpen(m.srv, path, doc.Version, text); err != nil {
+		return nil, err
+	}
+
+	m.docs[path] = m.lru.PushFront(doc)
+
+	for m.lru.Len() > m.limit {
+		if err := m.Close(m.lru.Back().Value.(*Document).Path); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// Lookup returns the open document at path, or nil if it is not open.
+func (m *DocumentManager) Lookup(path string) *Document {
+	if e, ok := m.docs[path]; ok {
+		return e.Value.(*Document)
+	}
+
+	return nil
+}
+
+// Close closes the document at path if it is open.
+func (m *DocumentManager) Close(path string) error {
+	e, ok := m.docs[path]
+	if !ok {
+		return nil
+	}
+
+	m.lru.Remove(e)
+	delete(m.docs, path)
+
+	return TextDocumentDidClose(m.srv, path)
+}
+
+// CloseAll closes all the open documents.
+func (m *DocumentManager) CloseAll() error {
+	for m.lru.Len() > 0 {
+		if err := m.Close(m.lru.Front().Value.(*Document).Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+)
+
+// documentEvents returns the document notifications that fake has
+// received, as "method file version". File is the base name of the
+// document, and the version is 0 for didClose.
+func documentEvents(t *testing.T, fake *lsptest.Server, s *lsp.Server) []string {
+	t.Helper()
+
+	// Notifications are handled in order, so once a request is
+	// answered, the fake has seen every earlier notification.
+	if _, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+
+	for _, m := range fake.Received() {
+		if !strings.HasPrefix(m.Method, "textDocument/did") {
+			continue
+		}
+
+		var params struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+			} `json:"textDocument"`
+		}
+
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, fmt.Sprintf("%s %s %d",
+			strings.TrimPrefix(m.Method, "textDocument/"),
+			filepath.Base(params.TextDocument.URI),
+			params.TextDocument.Version))
+	}
+
+	return events
+}
+
+func TestDocumentManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	write := func(name string, text string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	a := write("a.c", "int a;\n")
+	b := write("b.c", "int b;\n")
+	c := write("c.c", "int c;\n")
+
+	fake := lsptest.NewServer()
+	fake.Definition(mainLocation)
+
+	s := startServer(t, fake)
+	m := lsp.NewDocumentManager(s, 2)
+
+	open := func(path string) *lsp.Document {
+		t.Helper()
+
+		doc, err := m.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return doc
+	}
+
+	open(a)
+	open(b)
+
+	// An unchanged document isn't sent again, but it becomes the
+	// most recently used.
+	if doc := open(a); doc.Version != 1 || doc.Text != "int a;\n" {
+		t.Errorf("got version %d %q, want version 1", doc.Version, doc.Text)
+	}
+
+	// Opening a third document closes the least recently used.
+	open(c)
+
+	// A document that changed on disk is sent in full.
+	write("a.c", "int a, aa;\n")
+
+	if doc := open(a); doc.Version != 2 || doc.Text != "int a, aa;\n" {
+		t.Errorf("got version %d %q, want version 2", doc.Version, doc.Text)
+	}
+
+	// An unsaved buffer replaces the content until the next Open.
+	if _, err := m.Update(a, "int buffer;\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc := open(a); doc.Version != 4 || doc.Text != "int a, aa;\n" {
+		t.Errorf("got version %d %q, want version 4", doc.Version, doc.Text)
+	}
+
+	if err := m.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"didOpen a.c 1",
+		"didOpen b.c 1",
+		"didOpen c.c 1",
+		"didClose b.c 0",
+		"didChange a.c 2",
+		"didChange a.c 3",
+		"didChange a.c 4",
+		"didClose a.c 0",
+		"didClose c.c 0",
+	}
+
+	if got := documentEvents(t, fake, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package lsp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LanguageTable maps file names to TextDocumentItem language
+// identifiers.
+//
+// https://microsoft.github.io/language-server-protocol/specification#textDocumentItem
+type LanguageTable struct {
+	// Header resolves the language of header files whose extension
+	// is shared between languages (e.g. ".h"). If it is nil, or
+	// returns an empty string, the default mapping is used.
+	Header func(path string) string
+
+	extensions map[string]string
+	headers    map[string]bool
+}
+
+// headerExtensions are ambiguous header file extensions that may
+// belong to C, C++ or Objective-C.
+var headerExtensions = map[string]bool{
+	".h": true,
+}
+
+// defaultLanguages maps file extensions to language identifiers.
+// Extensions are matched case-sensitively first, then in lower case.
+var defaultLanguages = map[string]string{
+	".c": "c",
+	".h": "c",
+
+	".C":    "cpp",
+	".cc":   "cpp",
+	".cp":   "cpp",
+	".cpp":  "cpp",
+	".cxx":  "cpp",
+	".c++":  "cpp",
+	".cppm": "cpp",
+	".ixx":  "cpp",
+	".hh":   "cpp",
+	".hpp":  "cpp",
+	".hxx":  "cpp",
+	".h++":  "cpp",
+	".inl":  "cpp",
+	".ipp":  "cpp",
+	".tcc":  "cpp",
+	".tpp":  "cpp",
+
+	".m":  "objective-c",
+	".mm": "objective-cpp",
+
+	".cu":  "cuda",
+	".cuh": "cuda",
+
+	".cs":    "csharp",
+	".d":     "d",
+	".dart":  "dart",
+	".go":    "go",
+	".hs":    "haskell",
+	".java":  "java",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".jsx":   "javascriptreact",
+	".kt":    "kotlin",
+	".kts":   "kotlin",
+	".lua":   "lua",
+	".ml":    "ocaml",
+	".mli":   "ocaml",
+	".php":   "php",
+	".py":    "python",
+	".pyi":   "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".scala": "scala",
+	".sh":    "shellscript",
+	".bash":  "shellscript",
+	".swift": "swift",
+	".ts":    "typescript",
+	".tsx":   "typescriptreact",
+	".zig":   "zig",
+}
+
+// NewLanguageTable returns a LanguageTable populated with the default
+// mappings.
+func NewLanguageTable() *LanguageTable {
+	t := &LanguageTable{
+		extensions: map[string]string{},
+		headers:    map[string]bool{},
+	}
+
+	for ext, id := range defaultLanguages {
+		t.extensions[ext] = id
+	}
+
+	for ext := range headerExtensions {
+		t.headers[ext] = true
+	}
+
+	return t
+}
+
+// Set maps files with the given extension to the language identifier
+// id, overriding any existing mapping and the Header resolver. The
+// leading "." in ext is optional.
+func (t *LanguageTable) Set(ext string, id string) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	t.extensions[ext] = id
+	delete(t.headers, ext)
+}
+
+// Language returns the language identifier for the file at path, or
+// an empty string if the language is not known.
+func (t *LanguageTable) Language(path string) string {
+	ext := filepath.Ext(path)
+
+	// Standard C++ library headers (e.g. <vector>) have no
+	// extension, but live in a "c++" include directory.
+	if ext == "" {
+		for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+			if dir == "c++" {
+				return "cpp"
+			}
+		}
+
+		return ""
+	}
+
+	if t.headers[ext] && t.Header != nil {
+		if id := t.Header(path); id != "" {
+			return id
+		}
+	}
+
+	if id, ok := t.extensions[ext]; ok {
+		return id
+	}
+
+	return t.extensions[strings.ToLower(ext)]
+}
+
+// Languages is the LanguageTable used by FileToLanguageID.
+var Languages = NewLanguageTable()
+
+// FileToLanguageID maps a file name to a TextDocumentItem language
+// identifier using the Languages table.
+func FileToLanguageID(path string) string {
+	return Languages.Language(path)
+}
@@ -0,0 +1,55 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguageTable(t *testing.T) {
+	table := NewLanguageTable()
+	table.Set("tpl", "cpp")
+	table.Set(".inc", "c")
+
+	// Headers under an "objc" directory are Objective-C.
+	table.Header = func(path string) string {
+		if strings.Contains(path, "/objc/") {
+			return "objective-c"
+		}
+
+		return ""
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"main.c", "c"},
+		{"util.h", "c"},
+		{"src/objc/view.h", "objective-c"},
+		{"src/objc/view.m", "objective-c"},
+		{"main.cc", "cpp"},
+		{"main.C", "cpp"},
+		{"UTIL.H", "c"},
+		{"main.CPP", "cpp"},
+		{"main.go", "go"},
+		{"main.tpl", "cpp"},
+		{"table.inc", "c"},
+		{"/usr/include/c++/10/vector", "cpp"},
+		{"/usr/include/stdio", ""},
+		{"Makefile", ""},
+		{"notes.txt", ""},
+	}
+
+	for _, tt := range tests {
+		if got := table.Language(tt.path); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// Set overrides the header resolver for that extension.
+	table.Set(".h", "cpp")
+
+	if got := table.Language("src/objc/view.h"); got != "cpp" {
+		t.Errorf("src/objc/view.h: got %q, want %q", got, "cpp")
+	}
+}
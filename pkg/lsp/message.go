@@ -1,12 +1,76 @@
 package lsp
 
+import (
+	"encoding/json"
+)
+
 // String returns a pointer to its argument.
 func String(s string) *string {
 	return &s
 }
 
+// GeneralClientCapabilities ...
+type GeneralClientCapabilities struct {
+	// The position encodings supported by the client, in
+	// decreasing order of preference. If omitted, only UTF-16 is
+	// supported.
+	PositionEncodings []PositionEncoding `json:"positionEncodings,omitempty"`
+}
+
+// WorkspaceClientCapabilities ...
+type WorkspaceClientCapabilities struct {
+	// The client supports workspace folders, and sends
+	// "workspace/didChangeWorkspaceFolders" notifications.
+	WorkspaceFolders bool `json:"workspaceFolders,omitempty"`
+
+	// Capabilities specific to the "workspace/didChangeWatchedFiles"
+	// notification.
+	DidChangeWatchedFiles *DidChangeWatchedFilesClientCapabilities `json:"didChangeWatchedFiles,omitempty"`
+}
+
+// DidChangeWatchedFilesClientCapabilities ...
+type DidChangeWatchedFilesClientCapabilities struct {
+	// The client supports dynamic registration of file watchers.
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+// WindowClientCapabilities ...
+type WindowClientCapabilities struct {
+	// The client supports server initiated progress, with the
+	// "window/workDoneProgress/create" request.
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
+
 // ClientCapabilities ...
 type ClientCapabilities struct {
+	Workspace *WorkspaceClientCapabilities `json:"workspace,omitempty"`
+
+	Window *WindowClientCapabilities `json:"window,omitempty"`
+
+	General *GeneralClientCapabilities `json:"general,omitempty"`
+
+	// OffsetEncoding is the clangd extension that predates
+	// GeneralClientCapabilities.PositionEncodings.
+	OffsetEncoding []PositionEncoding `json:"offsetEncoding,omitempty"`
+}
+
+// ServerCapabilities ...
+type ServerCapabilities struct {
+	// The position encoding the server picked from the encodings
+	// offered by the client. If omitted, it defaults to UTF-16.
+	PositionEncoding PositionEncoding `json:"positionEncoding,omitempty"`
+}
+
+// InitializeResult ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#initialize
+type InitializeResult struct {
+	// The capabilities the language server provides.
+	Capabilities ServerCapabilities `json:"capabilities"`
+
+	// OffsetEncoding is the clangd extension that predates
+	// ServerCapabilities.PositionEncoding.
+	OffsetEncoding PositionEncoding `json:"offsetEncoding,omitempty"`
 }
 
 // WorkspaceFolder ...
@@ -21,6 +85,23 @@ type WorkspaceFolder struct {
 	Name string `json:"name"`
 }
 
+// WorkspaceFoldersChangeEvent ...
+type WorkspaceFoldersChangeEvent struct {
+	// The array of added workspace folders.
+	Added []WorkspaceFolder `json:"added"`
+
+	// The array of the removed workspace folders.
+	Removed []WorkspaceFolder `json:"removed"`
+}
+
+// DidChangeWorkspaceFoldersParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#workspace_didChangeWorkspaceFolders
+type DidChangeWorkspaceFoldersParams struct {
+	// The actual workspace folder change event.
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
 const (
 	// TraceOff ...
 	TraceOff = "off"
@@ -128,6 +209,14 @@ type DocumentSymbolParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 }
 
+// WorkspaceSymbolParams are the parameters of a "workspace/symbol"
+// request.
+type WorkspaceSymbolParams struct {
+	// Query filters the symbols. Servers usually match it
+	// fuzzily against the symbol names.
+	Query string `json:"query"`
+}
+
 // TextDocumentItem is item to transfer a text document from
 // the client to the server.
 type TextDocumentItem struct {
@@ -155,6 +244,38 @@ type DidOpenTextDocumentParams struct {
 	TextDocument TextDocumentItem `json:"textDocument"`
 }
 
+// VersionedTextDocumentIdentifier identifies a specific version of a
+// text document.
+type VersionedTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+
+	// The version number of this document.
+	Version int `json:"version"`
+}
+
+// TextDocumentContentChangeEvent describes a change to a text
+// document. If the range is omitted, the new text is considered to
+// be the full content of the document.
+type TextDocumentContentChangeEvent struct {
+	// The range of the document that changed.
+	Range *Range `json:"range,omitempty"`
+
+	// The new text for the provided range, or the whole document.
+	Text string `json:"text"`
+}
+
+// DidChangeTextDocumentParams is sent from the client to the server
+// to signal changes to a text document.
+type DidChangeTextDocumentParams struct {
+	// The document that did change. The version number points
+	// to the version after all provided content changes have
+	// been applied.
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+
+	// The actual content changes.
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
 // DidCloseTextDocumentParams is sent from the client to the server when
 // the document got closed in the client. The document’s truth now
 // exists where the document’s uri points to (e.g. if the document’s
@@ -275,7 +396,7 @@ type SymbolInformation struct {
 	Kind int `json:"kind"`
 
 	// Deprecated indicates if this symbol is deprecated.
-	Deprecated bool `json:"deprecated, omitempty"`
+	Deprecated bool `json:"deprecated,omitempty"`
 
 	// Location is the location of this symbol. The location's
 	// range is used by a tool to reveal the location in the
@@ -294,5 +415,210 @@ type SymbolInformation struct {
 	// (e.g. to render a qualifier in the user interface if
 	// necessary). It can't be used to re-infer a hierarchy for
 	// the document symbols.
-	ContainerName *string `json:"containerName, omitempty"`
+	ContainerName *string `json:"containerName,omitempty"`
+}
+
+// Registration is a capability that the server registers with the
+// client.
+type Registration struct {
+	// The id used to register the request. The id can be used to
+	// deregister the request again.
+	ID string `json:"id"`
+
+	// The method / capability to register for.
+	Method string `json:"method"`
+
+	// Options necessary for the registration.
+	RegisterOptions json.RawMessage `json:"registerOptions,omitempty"`
+}
+
+// RegistrationParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#client_registerCapability
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+// Unregistration ...
+type Unregistration struct {
+	// The id used to unregister the request or notification.
+	ID string `json:"id"`
+
+	// The method / capability to unregister for.
+	Method string `json:"method"`
+}
+
+// UnregistrationParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#client_unregisterCapability
+type UnregistrationParams struct {
+	// This should correctly be named "unregistrations", but the
+	// protocol keeps the misspelling for compatibility.
+	Unregisterations []Unregistration `json:"unregisterations"`
+}
+
+// WatchKind is a bit mask of the changes that a FileSystemWatcher is
+// interested in.
+type WatchKind int
+
+const (
+	// WatchKindCreate is interested in create events.
+	WatchKindCreate WatchKind = 1
+
+	// WatchKindChange is interested in change events.
+	WatchKindChange WatchKind = 2
+
+	// WatchKindDelete is interested in delete events.
+	WatchKindDelete WatchKind = 4
+)
+
+// FileSystemWatcher ...
+type FileSystemWatcher struct {
+	// The glob pattern to watch. This is either a string, or
+	// a RelativePattern.
+	GlobPattern json.RawMessage `json:"globPattern"`
+
+	// The kind of events of interest. If omitted it defaults to
+	// WatchKindCreate | WatchKindChange | WatchKindDelete.
+	Kind WatchKind `json:"kind,omitempty"`
+}
+
+// RelativePattern is a glob pattern that is matched against paths
+// relative to a base URI.
+type RelativePattern struct {
+	// A workspace folder or a base URI to which this pattern will
+	// be matched against relatively.
+	BaseURI json.RawMessage `json:"baseUri"`
+
+	// The actual glob pattern.
+	Pattern string `json:"pattern"`
+}
+
+// DidChangeWatchedFilesRegistrationOptions ...
+type DidChangeWatchedFilesRegistrationOptions struct {
+	// The watchers to register.
+	Watchers []FileSystemWatcher `json:"watchers"`
+}
+
+// FileChangeType is the kind of a file event.
+type FileChangeType int
+
+const (
+	// FileChangeTypeCreated means the file got created.
+	FileChangeTypeCreated FileChangeType = 1
+
+	// FileChangeTypeChanged means the file got changed.
+	FileChangeTypeChanged FileChangeType = 2
+
+	// FileChangeTypeDeleted means the file got deleted.
+	FileChangeTypeDeleted FileChangeType = 3
+)
+
+// FileEvent describes a file change.
+type FileEvent struct {
+	// The file's URI.
+	URI string `json:"uri"`
+
+	// The change type.
+	Type FileChangeType `json:"type"`
+}
+
+// DidChangeWatchedFilesParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#workspace_didChangeWatchedFiles
+type DidChangeWatchedFilesParams struct {
+	// The actual file events.
+	Changes []FileEvent `json:"changes"`
+}
+
+// ConfigurationParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#workspace_configuration
+type ConfigurationParams struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+// ProgressParams ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#progress
+type ProgressParams struct {
+	// The progress token, which is a string or an integer.
+	Token json.RawMessage `json:"token"`
+
+	// The progress data. For work done progress, this is a
+	// WorkDoneProgress.
+	Value json.RawMessage `json:"value"`
+}
+
+// WorkDoneProgress holds the fields that are common to the "begin",
+// "report" and "end" work done progress values.
+//
+// https://microsoft.github.io/language-server-protocol/specification#workDoneProgress
+type WorkDoneProgress struct {
+	// Kind is "begin", "report" or "end".
+	Kind string `json:"kind"`
+
+	// Title is the title of the work, in a "begin" value.
+	Title string `json:"title,omitempty"`
+
+	// Message is an optional progress message.
+	Message string `json:"message,omitempty"`
+}
+
+// CallHierarchyItem ...
+//
+// https://microsoft.github.io/language-server-protocol/specification#textDocument_prepareCallHierarchy
+type CallHierarchyItem struct {
+	// The name of this item.
+	Name string `json:"name"`
+
+	// The kind of this item.
+	Kind SymbolKind `json:"kind"`
+
+	// More detail for this item, e.g. the signature of a function.
+	Detail string `json:"detail,omitempty"`
+
+	// The resource identifier of this item.
+	URI string `json:"uri"`
+
+	// The range enclosing this symbol not including leading/trailing
+	// whitespace but everything else, e.g. comments and code.
+	Range Range `json:"range"`
+
+	// The range that should be selected and revealed when this
+	// symbol is being picked, e.g. the name of a function.
+	SelectionRange Range `json:"selectionRange"`
+
+	// A data entry field that is preserved between a call
+	// hierarchy prepare and incoming calls or outgoing calls
+	// requests.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// CallHierarchyCallsParams are the parameters of the
+// "callHierarchy/incomingCalls" and "callHierarchy/outgoingCalls"
+// requests.
+type CallHierarchyCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyIncomingCall ...
+type CallHierarchyIncomingCall struct {
+	// The item that makes the call.
+	From CallHierarchyItem `json:"from"`
+
+	// The ranges at which the calls appear. This is relative to
+	// the caller denoted by From.
+	FromRanges []Range `json:"fromRanges"`
+}
+
+// CallHierarchyOutgoingCall ...
+type CallHierarchyOutgoingCall struct {
+	// The item that is called.
+	To CallHierarchyItem `json:"to"`
+
+	// The range at which this item is called. This is the range
+	// relative to the caller, i.e. the item passed to the
+	// "callHierarchy/outgoingCalls" request.
+	FromRanges []Range `json:"fromRanges"`
 }
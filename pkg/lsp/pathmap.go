@@ -0,0 +1,138 @@
+package lsp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PathMapping maps the directory Host on our file system to the
+// directory Server on the file system that the language server sees.
+// This is needed when the server runs in a container, where the
+// sources are mounted at a different path.
+type PathMapping struct {
+	Host   string
+	Server string
+}
+
+// PathMap is a list of PathMappings. The first mapping that matches
+// a path is used, and paths that no mapping matches are unchanged.
+type PathMap []PathMapping
+
+// mapPrefix replaces the directory from at the start of path with to.
+func mapPrefix(path string, from string, to string) (string, bool) {
+	rel, err := filepath.Rel(from, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return path, false
+	}
+
+	return filepath.Join(to, rel), true
+}
+
+// ToServer maps a path on our file system to the server's file system.
+func (m PathMap) ToServer(path string) string {
+	for _, p := range m {
+		if mapped, ok := mapPrefix(path, p.Host, p.Server); ok {
+			return mapped
+		}
+	}
+
+	return path
+}
+
+// ToHost maps a path on the server's file system to our file system.
+func (m PathMap) ToHost(path string) string {
+	for _, p := range m {
+		if mapped, ok := mapPrefix(path, p.Server, p.Host); ok {
+			return mapped
+		}
+	}
+
+	return path
+}
+
+// serverURI returns the URI that the server uses for path, which may
+// also be a "file" URI on our file system.
+func (m PathMap) serverURI(path string) string {
+	if strings.HasPrefix(path, "file://") {
+		p, err := URIToPath(path)
+		if err != nil {
+			return path
+		}
+
+		path = p
+	}
+
+	if len(m) == 0 {
+		return FileToURI(path)
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return FileToURI(m.ToServer(path))
+}
+
+// hostURI maps a URI from the server to our file system. URIs that are
+// not for local files are unchanged.
+func (m PathMap) hostURI(uri string) string {
+	if len(m) == 0 {
+		return uri
+	}
+
+	path, err := URIToPath(uri)
+	if err != nil {
+		return uri
+	}
+
+	return FileToURI(m.ToHost(path))
+}
+
+// OptPathMap maps paths between our file system and the server's file
+// system. It may be given more than once.
+func OptPathMap(m PathMap) ServerOption {
+	return func(s *srvOpts) {
+		s.paths = append(s.paths, m...)
+	}
+}
+
+// OptLauncher starts the server by running the given command with the
+// server path and arguments appended, for example "docker exec -i
+// build" to run the server in a container. Environment variables are
+// given to the launcher rather than to the server itself.
+func OptLauncher(cmd []string) ServerOption {
+	return func(s *srvOpts) {
+		s.launcher = cmd
+	}
+}
+
+// pathMap returns the path mappings for the server.
+func (s *Server) pathMap() PathMap {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.paths
+}
+
+// ServerURI returns the URI that the server uses for the file at path
+// on our file system. The path may also be a "file" URI.
+func (s *Server) ServerURI(path string) string {
+	return s.pathMap().serverURI(path)
+}
+
+// HostURI maps a URI from the server to our file system.
+func (s *Server) HostURI(uri string) string {
+	return s.pathMap().hostURI(uri)
+}
+
+// HostLocations maps the URIs of Locations from the server to our file
+// system, in place, and returns the Locations.
+func (s *Server) HostLocations(loc []Location) []Location {
+	m := s.pathMap()
+
+	for i := range loc {
+		loc[i].URI = m.hostURI(loc[i].URI)
+	}
+
+	return loc
+}
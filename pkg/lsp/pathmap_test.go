@@ -0,0 +1,87 @@
+package lsp
+
+import (
+	"testing"
+)
+
+func TestPathMap(t *testing.T) {
+	m := PathMap{
+		{Host: "/home/me/proj", Server: "/src"},
+		{Host: "/home/me/proj/build", Server: "/build"},
+		{Host: "/home/me/lib", Server: "/src/third_party/lib"},
+	}
+
+	tests := []struct {
+		host   string
+		server string
+	}{
+		{"/home/me/proj", "/src"},
+		{"/home/me/proj/main.c", "/src/main.c"},
+		{"/home/me/proj/sub/dir/x.h", "/src/sub/dir/x.h"},
+		{"/home/me/lib/x.c", "/src/third_party/lib/x.c"},
+		{"/home/me/project/x.c", "/home/me/project/x.c"},
+		{"/usr/include/stdio.h", "/usr/include/stdio.h"},
+	}
+
+	for _, tt := range tests {
+		if got := m.ToServer(tt.host); got != tt.server {
+			t.Errorf("ToServer(%s): got %s, want %s", tt.host, got, tt.server)
+		}
+	}
+
+	// The first matching mapping wins, in both directions.
+	back := []struct {
+		server string
+		host   string
+	}{
+		{"/src/main.c", "/home/me/proj/main.c"},
+		{"/src/third_party/lib/x.c", "/home/me/proj/third_party/lib/x.c"},
+		{"/build/x.o", "/home/me/proj/build/x.o"},
+		{"/srcs/x.c", "/srcs/x.c"},
+		{"/usr/include/stdio.h", "/usr/include/stdio.h"},
+	}
+
+	for _, tt := range back {
+		if got := m.ToHost(tt.server); got != tt.host {
+			t.Errorf("ToHost(%s): got %s, want %s", tt.server, got, tt.host)
+		}
+	}
+
+	if got := m.ToServer("/home/me/proj/build/x.o"); got != "/src/build/x.o" {
+		t.Errorf("ToServer(/home/me/proj/build/x.o): got %s, want /src/build/x.o", got)
+	}
+}
+
+func TestPathMapURI(t *testing.T) {
+	m := PathMap{{Host: "/home/me/my proj", Server: "/src"}}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/home/me/my proj/a.c", "file:///src/a.c"},
+		{"file:///home/me/my%20proj/a.c", "file:///src/a.c"},
+		{"/tmp/x.c", "file:///tmp/x.c"},
+	}
+
+	for _, tt := range tests {
+		if got := m.serverURI(tt.in); got != tt.want {
+			t.Errorf("serverURI(%s): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	hosts := []struct {
+		in   string
+		want string
+	}{
+		{"file:///src/a.c", "file:///home/me/my%20proj/a.c"},
+		{"file:///usr/include/stdio.h", "file:///usr/include/stdio.h"},
+		{"untitled:Untitled-1", "untitled:Untitled-1"},
+	}
+
+	for _, tt := range hosts {
+		if got := m.hostURI(tt.in); got != tt.want {
+			t.Errorf("hostURI(%s): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package lsp
+
+import (
+	"unicode/utf8"
+)
+
+// PositionEncoding is the unit in which the Character offset of a
+// Position is counted.
+//
+// https://microsoft.github.io/language-server-protocol/specification#positionEncodingKind
+type PositionEncoding string
+
+const (
+	// PositionEncodingUTF8 counts characters in bytes.
+	PositionEncodingUTF8 PositionEncoding = "utf-8"
+
+	// PositionEncodingUTF16 counts characters in UTF-16 code
+	// units. This is the default encoding that all servers support.
+	PositionEncodingUTF16 PositionEncoding = "utf-16"
+
+	// PositionEncodingUTF32 counts characters in Unicode code points.
+	PositionEncodingUTF32 PositionEncoding = "utf-32"
+)
+
+// positionEncodings lists the encodings we offer to the server, in
+// order of preference. Vim reports columns as byte offsets, so UTF-8
+// needs no conversion at all.
+var positionEncodings = []PositionEncoding{
+	PositionEncodingUTF8,
+	PositionEncodingUTF16,
+	PositionEncodingUTF32,
+}
+
+// runeWidth returns the number of units that r occupies in the
+// given encoding.
+func runeWidth(r rune, size int, enc PositionEncoding) int {
+	switch enc {
+	case PositionEncodingUTF8:
+		return size
+	case PositionEncodingUTF32:
+		return 1
+	default:
+		// Runes outside the basic multilingual plane take a
+		// UTF-16 surrogate pair.
+		if r >= 0x10000 {
+			return 2
+		}
+		return 1
+	}
+}
+
+// ByteToCharacter converts a byte offset in line to a Character offset
+// in the given encoding. Offsets past the end of the line are clamped
+// to the line length, and negative offsets to 0.
+func ByteToCharacter(line string, offset int, enc PositionEncoding) int {
+	if enc == PositionEncodingUTF8 {
+		switch {
+		case offset < 0:
+			return 0
+		case offset > len(line):
+			return len(line)
+		}
+		return offset
+	}
+
+	char := 0
+
+	for i := 0; i < offset && i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		char += runeWidth(r, size, enc)
+		i += size
+	}
+
+	return char
+}
+
+// CharacterToByte converts a Character offset in the given encoding
+// to a byte offset in line. Offsets past the end of the line are
+// clamped to the line length, and negative offsets to 0.
+func CharacterToByte(line string, char int, enc PositionEncoding) int {
+	if enc == PositionEncodingUTF8 {
+		switch {
+		case char < 0:
+			return 0
+		case char > len(line):
+			return len(line)
+		}
+		return char
+	}
+
+	i := 0
+
+	for n := 0; n < char && i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		n += runeWidth(r, size, enc)
+		i += size
+	}
+
+	return i
+}
@@ -0,0 +1,93 @@
+package lsp
+
+import (
+	"testing"
+)
+
+func TestByteToCharacter(t *testing.T) {
+	// "é" is 2 bytes and 1 UTF-16 unit, "€" is 3 bytes and 1 UTF-16
+	// unit, and "😀" is 4 bytes and a UTF-16 surrogate pair.
+	tests := []struct {
+		line   string
+		offset int
+		enc    PositionEncoding
+		want   int
+	}{
+		{"int x;", 4, PositionEncodingUTF16, 4},
+		{"int x;", 4, PositionEncodingUTF8, 4},
+		{"int x;", 4, PositionEncodingUTF32, 4},
+		{"é = x;", 5, PositionEncodingUTF8, 5},
+		{"é = x;", 5, PositionEncodingUTF16, 4},
+		{"é = x;", 5, PositionEncodingUTF32, 4},
+		{"s = \"€\"; x", 11, PositionEncodingUTF16, 9},
+		{"s = \"€\"; x", 11, PositionEncodingUTF32, 9},
+		{"/* 😀 */ x", 11, PositionEncodingUTF16, 9},
+		{"/* 😀 */ x", 11, PositionEncodingUTF32, 8},
+		{"/* 😀 */ x", 11, PositionEncodingUTF8, 11},
+		{"😀😀x", 8, PositionEncodingUTF16, 4},
+		{"", 0, PositionEncodingUTF16, 0},
+		{"int x;", 0, PositionEncodingUTF16, 0},
+		{"int x;", 20, PositionEncodingUTF8, 6},
+		{"int x;", 20, PositionEncodingUTF16, 6},
+		{"é😀", 20, PositionEncodingUTF16, 3},
+		{"é😀", 20, PositionEncodingUTF32, 2},
+		{"int x;", -1, PositionEncodingUTF8, 0},
+		{"int x;", -1, PositionEncodingUTF16, 0},
+	}
+
+	for _, tt := range tests {
+		if got := ByteToCharacter(tt.line, tt.offset, tt.enc); got != tt.want {
+			t.Errorf("ByteToCharacter(%q, %d, %s): got %d, want %d",
+				tt.line, tt.offset, tt.enc, got, tt.want)
+		}
+	}
+}
+
+func TestCharacterToByte(t *testing.T) {
+	tests := []struct {
+		line string
+		char int
+		enc  PositionEncoding
+		want int
+	}{
+		{"int x;", 4, PositionEncodingUTF16, 4},
+		{"int x;", 4, PositionEncodingUTF8, 4},
+		{"int x;", 4, PositionEncodingUTF32, 4},
+		{"é = x;", 4, PositionEncodingUTF16, 5},
+		{"é = x;", 4, PositionEncodingUTF32, 5},
+		{"é = x;", 5, PositionEncodingUTF8, 5},
+		{"s = \"€\"; x", 9, PositionEncodingUTF16, 11},
+		{"/* 😀 */ x", 9, PositionEncodingUTF16, 11},
+		{"/* 😀 */ x", 8, PositionEncodingUTF32, 11},
+		{"😀😀x", 4, PositionEncodingUTF16, 8},
+		{"", 0, PositionEncodingUTF16, 0},
+		{"int x;", 20, PositionEncodingUTF8, 6},
+		{"int x;", 20, PositionEncodingUTF16, 6},
+		{"é😀", 20, PositionEncodingUTF16, 6},
+		{"é😀", 20, PositionEncodingUTF32, 6},
+		{"int x;", -1, PositionEncodingUTF8, 0},
+		{"int x;", -1, PositionEncodingUTF16, 0},
+	}
+
+	for _, tt := range tests {
+		if got := CharacterToByte(tt.line, tt.char, tt.enc); got != tt.want {
+			t.Errorf("CharacterToByte(%q, %d, %s): got %d, want %d",
+				tt.line, tt.char, tt.enc, got, tt.want)
+		}
+	}
+}
+
+func TestCharacterRoundTrip(t *testing.T) {
+	line := "a é € 😀 z"
+
+	for _, enc := range positionEncodings {
+		// Every rune boundary converts back to itself.
+		for i := range line {
+			char := ByteToCharacter(line, i, enc)
+
+			if got := CharacterToByte(line, char, enc); got != i {
+				t.Errorf("%s: byte %d is character %d, which is byte %d", enc, i, char, got)
+			}
+		}
+	}
+}
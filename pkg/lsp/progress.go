@@ -0,0 +1,133 @@
+package lsp
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// progress tracks the work that a server reports with "$/progress"
+// notifications, or with cquery's "$cquery/progress" notifications,
+// such as indexing the workspace.
+type progress struct {
+	lock sync.Mutex
+
+	// active holds the tokens of the work that has begun, but not
+	// yet ended.
+	active map[string]bool
+
+	// cquery is true while cquery has indexing work queued.
+	cquery bool
+
+	// last is when the server last reported any progress.
+	last time.Time
+
+	// changed is closed, and replaced, when the server reports
+	// progress.
+	changed chan struct{}
+}
+
+func newProgress() *progress {
+	return &progress{
+		active:  map[string]bool{},
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// update applies a change to the progress, and wakes any waiters.
+func (p *progress) update(change func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	change()
+
+	p.last = time.Now()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// create records a "window/workDoneProgress/create" request, which
+// announces work that is about to begin.
+func (p *progress) create() {
+	p.update(func() {})
+}
+
+// workDone records a "$/progress" notification.
+func (p *progress) workDone(params *ProgressParams) {
+	var value WorkDoneProgress
+
+	// Other kinds of progress, such as partial results, have no
+	// kind, and are ignored.
+	if err := json.Unmarshal(params.Value, &value); err != nil || value.Kind == "" {
+		return
+	}
+
+	token := string(params.Token)
+
+	p.update(func() {
+		switch value.Kind {
+		case "begin", "report":
+			p.active[token] = true
+		case "end":
+			delete(p.active, token)
+		}
+	})
+}
+
+// cqueryProgress records a "$cquery/progress" notification, whose
+// fields all count work that cquery has queued or is doing.
+func (p *progress) cqueryProgress(counts map[string]int) {
+	busy := false
+
+	for _, n := range counts {
+		if n > 0 {
+			busy = true
+		}
+	}
+
+	p.update(func() {
+		p.cquery = busy
+	})
+}
+
+// wait waits until no work is in progress, and the server has reported
+// no progress for the quiet period, counted from when wait is called
+// at the earliest. It returns false if the server is still busy when
+// the timeout expires.
+func (p *progress) wait(quiet time.Duration, timeout time.Duration) bool {
+	start := time.Now()
+	deadline := time.After(timeout)
+
+	for {
+		p.lock.Lock()
+
+		busy := len(p.active) > 0 || p.cquery
+		since := p.last
+		changed := p.changed
+
+		p.lock.Unlock()
+
+		if since.Before(start) {
+			since = start
+		}
+
+		var idle <-chan time.Time
+
+		if !busy {
+			left := quiet - time.Since(since)
+			if left <= 0 {
+				return true
+			}
+
+			idle = time.After(left)
+		}
+
+		select {
+		case <-changed:
+		case <-idle:
+		case <-deadline:
+			return false
+		}
+	}
+}
@@ -21,10 +21,3 @@ func (r *rwc) Close() error {
 	// Don't close, because really the Server owns these files.
 	return nil
 }
-
-func (r *rwc) Tee(out io.Writer) io.ReadWriteCloser {
-	return &rwc{
-		write: io.MultiWriter(r.write, out),
-		read:  io.TeeReader(r.read, out),
-	}
-}
@@ -2,11 +2,14 @@ package lsp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"sync"
+	"time"
 
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -18,8 +21,30 @@ type srvOpts struct {
 	// Args are additional arguments passed to the LSP server at launch.
 	args []string
 
-	traceEnabled bool
-	traceWriter  io.Writer
+	// Env holds additional environment variables, in "key=value"
+	// form, for the LSP server.
+	env []string
+
+	// Timeout limits how long a Call waits for a response.
+	timeout time.Duration
+
+	// Tracers observe the messages exchanged with the server.
+	tracers []Tracer
+
+	// dial, if not nil, connects to the server instead of
+	// starting a server process.
+	dial func() (io.ReadWriteCloser, error)
+
+	// watchDelay, if not zero, enables file watching and is the
+	// delay used to debounce file changes.
+	watchDelay time.Duration
+
+	// paths maps paths between our file system and the server's.
+	paths PathMap
+
+	// launcher, if not empty, is a command prefix used to start
+	// the server.
+	launcher []string
 }
 
 // ServerOption is a startup option for the LDP server.
@@ -39,11 +64,58 @@ func OptArgs(args []string) ServerOption {
 	}
 }
 
-// OptTrace enables message tracing to the given io.Writer.
-func OptTrace(out io.Writer) ServerOption {
+// OptEnv adds environment variables, in "key=value" form, to the
+// environment of the LSP server.
+func OptEnv(env []string) ServerOption {
 	return func(s *srvOpts) {
-		s.traceEnabled = true
-		s.traceWriter = out
+		s.env = append(s.env, env...)
+	}
+}
+
+// OptTimeout limits how long each Call waits for a response.
+func OptTimeout(timeout time.Duration) ServerOption {
+	return func(s *srvOpts) {
+		s.timeout = timeout
+	}
+}
+
+// OptConn connects to the server with the given dial function, rather
+// than starting a server process. The dial function is called each
+// time the Server is started.
+func OptConn(dial func() (io.ReadWriteCloser, error)) ServerOption {
+	return func(s *srvOpts) {
+		s.dial = dial
+	}
+}
+
+// OptWatch watches the workspace roots for changes to the files that
+// the server registers an interest in, and sends the changes once no
+// further changes have happened for the given delay. File watching is
+// only supported on Linux.
+func OptWatch(delay time.Duration) ServerOption {
+	return func(s *srvOpts) {
+		s.watchDelay = delay
+	}
+}
+
+// Tracer observes the JSON-RPC messages exchanged with a server.
+type Tracer interface {
+	// Send is called for each message sent to the server. For
+	// requests and notifications, resp is nil. For responses,
+	// req is nil.
+	Send(req *jsonrpc2.Request, resp *jsonrpc2.Response)
+
+	// Recv is called for each message received from the server.
+	// For requests and notifications, resp is nil. For responses,
+	// req is the request being answered, if it is known.
+	Recv(req *jsonrpc2.Request, resp *jsonrpc2.Response)
+}
+
+// OptTrace enables message tracing to the given Tracer. It may be
+// given more than once.
+func OptTrace(t Tracer) ServerOption {
+	return func(s *srvOpts) {
+		s.tracers = append(s.tracers, t)
 	}
 }
 
@@ -58,16 +130,135 @@ func NewServer() (*Server, error) {
 	}, nil
 }
 
+// handler answers the requests that the server sends to us. It runs
+// on the connection's read goroutine, and a Call waits for that
+// goroutine, so it must not call the server.
 type handler struct {
+	// watcher is nil if file watching is disabled.
+	watcher *watcher
+
+	// progress tracks the work that the server reports.
+	progress *progress
 }
 
 func (h *handler) Handle(ctx context.Context, c *jsonrpc2.Conn, r *jsonrpc2.Request) {
+	// Notifications don't get a reply.
+	if r.Notif {
+		h.notify(r)
+		return
+	}
+
+	var result interface{}
+	var err error
+
 	switch r.Method {
+	case "client/registerCapability":
+		err = h.register(r)
+	case "client/unregisterCapability":
+		err = h.unregister(r)
+	case "window/workDoneProgress/create":
+		h.progress.create()
+	case "workspace/configuration":
+		// We have no configuration, which is answered with a
+		// null for each requested item.
+		var params ConfigurationParams
+
+		if err = unmarshalParams(r, &params); err == nil {
+			result = make([]interface{}, len(params.Items))
+		}
 	default:
+		err = &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeMethodNotFound,
+			Message: fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+
+	if err != nil {
+		rpcErr, ok := err.(*jsonrpc2.Error)
+		if !ok {
+			rpcErr = &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		c.ReplyWithError(ctx, r.ID, rpcErr)
+		return
+	}
+
+	c.Reply(ctx, r.ID, result)
+}
+
+// notify handles the notifications that the server sends to us. Any
+// that we don't use are ignored.
+func (h *handler) notify(r *jsonrpc2.Request) {
+	switch r.Method {
+	case "$/progress":
+		var params ProgressParams
+
+		if err := unmarshalParams(r, &params); err == nil {
+			h.progress.workDone(&params)
+		}
+	case "$cquery/progress":
+		var counts map[string]int
+
+		if err := unmarshalParams(r, &counts); err == nil {
+			h.progress.cqueryProgress(counts)
+		}
 	}
 }
 
-// Server is an instance of a LSP server process.
+func (h *handler) register(r *jsonrpc2.Request) error {
+	var params RegistrationParams
+
+	if err := unmarshalParams(r, &params); err != nil {
+		return err
+	}
+
+	for _, reg := range params.Registrations {
+		// We accept, but ignore, registrations for features that
+		// we don't use.
+		if reg.Method != "workspace/didChangeWatchedFiles" || h.watcher == nil {
+			continue
+		}
+
+		var opts DidChangeWatchedFilesRegistrationOptions
+
+		if err := json.Unmarshal(reg.RegisterOptions, &opts); err != nil {
+			return err
+		}
+
+		if err := h.watcher.register(reg.ID, &opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *handler) unregister(r *jsonrpc2.Request) error {
+	var params UnregistrationParams
+
+	if err := unmarshalParams(r, &params); err != nil {
+		return err
+	}
+
+	for _, u := range params.Unregisterations {
+		if u.Method == "workspace/didChangeWatchedFiles" && h.watcher != nil {
+			h.watcher.unregister(u.ID)
+		}
+	}
+
+	return nil
+}
+
+func unmarshalParams(r *jsonrpc2.Request, params interface{}) error {
+	if r.Params == nil {
+		return errors.New("missing params")
+	}
+
+	return json.Unmarshal(*r.Params, params)
+}
+
+// Server is an instance of a LSP server process, or a connection
+// to a LSP server.
 type Server struct {
 	cmd  *exec.Cmd
 	lock *sync.Mutex
@@ -77,15 +268,72 @@ type Server struct {
 
 	in  io.WriteCloser
 	out io.ReadCloser
+
+	// transport is the connection to the server if it was dialed
+	// rather than started.
+	transport io.ReadWriteCloser
+
+	// encoding is the position encoding negotiated at initialization.
+	encoding PositionEncoding
+
+	// timeout limits how long a Call waits for a response.
+	timeout time.Duration
+
+	// watcher watches files for the server, if file watching is
+	// enabled.
+	watcher *watcher
+
+	// paths maps paths between our file system and the server's.
+	paths PathMap
+
+	// progress tracks the work that the server that was last
+	// started reports.
+	progress *progress
+
+	// stopped is closed when the server that was last started has
+	// stopped, and the Server has been reset.
+	stopped chan struct{}
+}
+
+// PositionEncoding returns the encoding of the Character offset in
+// positions exchanged with this server.
+func (s *Server) PositionEncoding() PositionEncoding {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.encoding == "" {
+		return PositionEncodingUTF16
+	}
+
+	return s.encoding
+}
+
+func (s *Server) setPositionEncoding(enc PositionEncoding) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.encoding = enc
 }
 
 func (s *Server) rwc() *rwc {
+	if s.transport != nil {
+		return &rwc{
+			write: s.transport,
+			read:  s.transport,
+		}
+	}
+
 	return &rwc{
 		write: s.in,
 		read:  s.out,
 	}
 }
 
+// running returns true if the server is running. The lock must be held.
+func (s *Server) running() bool {
+	return s.cmd != nil || s.transport != nil
+}
+
 func (s *Server) reset() {
 	if s.in != nil {
 		s.in.Close()
@@ -97,16 +345,69 @@ func (s *Server) reset() {
 		s.out = nil
 	}
 
+	if s.transport != nil {
+		s.transport.Close()
+		s.transport = nil
+	}
+
+	if s.watcher != nil {
+		s.watcher.close()
+		s.watcher = nil
+	}
+
 	s.cmd = nil
 }
 
-func (s *Server) start(path string, args []string, trace io.Writer) error {
+// connect creates the JSON-RPC connection to the server.
+func (s *Server) connect(options *srvOpts) {
+	rpcOpt := []jsonrpc2.ConnOpt{}
+
+	for _, t := range options.tracers {
+		rpcOpt = append(rpcOpt,
+			jsonrpc2.OnSend(t.Send),
+			jsonrpc2.OnRecv(t.Recv),
+		)
+	}
+
+	s.conn = jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(s.rwc(), jsonrpc2.VSCodeObjectCodec{}),
+		&handler{watcher: s.watcher, progress: s.progress},
+		rpcOpt...)
+}
+
+func (s *Server) dial(options *srvOpts) error {
+	var err error
+
+	s.transport, err = options.dial()
+	if err != nil {
+		s.reset()
+		return err
+	}
+
+	s.connect(options)
+
+	return nil
+}
+
+func (s *Server) start(options *srvOpts) error {
 	var err error
 
+	path, args := options.path, options.args
+
+	if len(options.launcher) > 0 {
+		args = append(append(append([]string{}, options.launcher[1:]...), path), args...)
+		path = options.launcher[0]
+	}
+
 	s.cmd = exec.Command(path, args...)
 	s.cmd.Stderr = os.Stderr
 	s.cmd.SysProcAttr = procattr()
 
+	if len(options.env) > 0 {
+		s.cmd.Env = append(os.Environ(), options.env...)
+	}
+
 	s.in, err = s.cmd.StdinPipe()
 	if err != nil {
 		s.reset()
@@ -125,21 +426,7 @@ func (s *Server) start(path string, args []string, trace io.Writer) error {
 		return err
 	}
 
-	var conn io.ReadWriteCloser
-
-	if trace != nil {
-		conn = s.rwc().Tee(trace)
-	} else {
-		conn = s.rwc()
-	}
-
-	rpcOpt := []jsonrpc2.ConnOpt{}
-
-	s.conn = jsonrpc2.NewConn(
-		context.Background(),
-		jsonrpc2.NewBufferedStream(conn, jsonrpc2.VSCodeObjectCodec{}),
-		&handler{},
-		rpcOpt...)
+	s.connect(options)
 
 	return nil
 }
@@ -155,28 +442,44 @@ func (s *Server) Start(opts []ServerOption) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if s.cmd != nil {
+	if s.running() {
 		return errors.New("server already running")
 	}
 
-	if options.traceEnabled {
-		if err := s.start(options.path, options.args, options.traceWriter); err != nil {
+	s.paths = options.paths
+	s.progress = newProgress()
+
+	if options.watchDelay > 0 && watchSupported {
+		s.watcher = newWatcher(s, options.watchDelay, options.paths)
+	}
+
+	if options.dial != nil {
+		if err := s.dial(&options); err != nil {
 			return err
 		}
 	} else {
-
-		if err := s.start(options.path, options.args, nil); err != nil {
+		if err := s.start(&options); err != nil {
 			return err
 		}
 	}
 
+	s.timeout = options.timeout
+	s.stopped = make(chan struct{})
+
+	cmd, conn, stopped := s.cmd, s.conn, s.stopped
+
 	go func() {
-		s.cmd.Wait()
+		if cmd != nil {
+			cmd.Wait()
+		} else {
+			<-conn.DisconnectNotify()
+		}
 
 		s.lock.Lock()
 		defer s.lock.Unlock()
 
 		s.reset()
+		close(stopped)
 
 		s.stop <- struct{}{}
 
@@ -191,36 +494,123 @@ func (s *Server) Start(opts []ServerOption) error {
 func (s *Server) Stop() {
 	s.lock.Lock()
 
-	if s.cmd == nil {
+	if !s.running() {
 		s.lock.Unlock()
 		return
 	}
 
-	s.cmd.Process.Kill()
+	if s.cmd != nil {
+		s.cmd.Process.Kill()
+	} else {
+		s.transport.Close()
+	}
+
 	s.lock.Unlock()
 	<-s.stop
 }
 
-// Call ...
-func (s *Server) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+// WaitIdle waits until the server has no work in progress, such as
+// indexing the workspace, and has reported no progress for the quiet
+// period. Servers that don't report progress are idle after the quiet
+// period. WaitIdle returns false if the server is still busy when the
+// timeout expires.
+func (s *Server) WaitIdle(quiet time.Duration, timeout time.Duration) bool {
+	s.lock.Lock()
+	p := s.progress
+	s.lock.Unlock()
+
+	if p == nil {
+		return true
+	}
+
+	return p.wait(quiet, timeout)
+}
+
+// fileWatcher returns the file watcher, or nil if file watching is
+// disabled.
+func (s *Server) fileWatcher() *watcher {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if s.cmd == nil {
+	return s.watcher
+}
+
+// Running returns true if the server is running.
+func (s *Server) Running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.running()
+}
+
+// stopWait is how long a failed request waits for the server to stop.
+const stopWait = 100 * time.Millisecond
+
+// connection returns the connection to the server, the call timeout and
+// the channel that is closed when the server stops, or ErrStopped if
+// the server is not running.
+func (s *Server) connection() (*jsonrpc2.Conn, time.Duration, chan struct{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.running() {
+		return nil, 0, nil, ErrStopped
+	}
+
+	return s.conn, s.timeout, s.stopped, nil
+}
+
+// connError returns ErrStopped if a request failed with err because
+// the server stopped. The connection reports its failure to pending
+// requests before the server is reset, so this waits a little for the
+// server to stop, so that Running is false once ErrStopped is returned.
+func connError(ctx context.Context, stopped chan struct{}, err error) error {
+	if _, ok := err.(*jsonrpc2.Error); ok || ctx.Err() != nil {
+		return err
+	}
+
+	select {
+	case <-stopped:
 		return ErrStopped
+	case <-time.After(stopWait):
+		return err
+	}
+}
+
+// Call sends a request to the server and waits for the response. The
+// Server lock is not held while waiting, so a server that never
+// answers doesn't block Stop or other requests. If the server stops
+// before it answers, Call returns ErrStopped.
+func (s *Server) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	conn, timeout, stopped, err := s.connection()
+	if err != nil {
+		return err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := conn.Call(ctx, method, params, result); err != nil {
+		return connError(ctx, stopped, err)
 	}
 
-	return s.conn.Call(ctx, method, params, result)
+	return nil
 }
 
 // Notify ...
 func (s *Server) Notify(ctx context.Context, method string, params interface{}) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	conn, _, stopped, err := s.connection()
+	if err != nil {
+		return err
+	}
 
-	if s.cmd == nil {
-		return ErrStopped
+	if err := conn.Notify(context.Background(), method, &params); err != nil {
+		return connError(ctx, stopped, err)
 	}
 
-	return s.conn.Notify(context.Background(), method, &params)
+	return nil
 }
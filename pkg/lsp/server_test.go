@@ -0,0 +1,266 @@
+package lsp_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+)
+
+// startServer starts a Server connected to fake, and initializes it.
+func startServer(t *testing.T, fake *lsptest.Server, opts ...lsp.ServerOption) *lsp.Server {
+	t.Helper()
+
+	s, err := lsp.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start(append(opts, lsp.OptConn(fake.Dial))); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(s.Stop)
+
+	if err := lsp.Initialize(s, []string{"/src"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// waitStopped waits for s to notice that it has stopped.
+func waitStopped(t *testing.T, s *lsp.Server) {
+	t.Helper()
+
+	for i := 0; s.Running(); i++ {
+		if i == 100 {
+			t.Fatal("server is still running")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var mainLocation = lsp.Location{
+	URI: "file:///src/main.c",
+	Range: lsp.Range{
+		Start: lsp.Position{Line: 1, Character: 4},
+		End:   lsp.Position{Line: 1, Character: 8},
+	},
+}
+
+func TestRestart(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.Definition(mainLocation)
+
+	s := startServer(t, fake)
+
+	loc, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loc, []lsp.Location{mainLocation}) {
+		t.Errorf("got %+v, want %+v", loc, mainLocation)
+	}
+
+	fake.Crash()
+	waitStopped(t, s)
+
+	if _, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0); err != lsp.ErrStopped {
+		t.Fatalf("got error %v, want %v", err, lsp.ErrStopped)
+	}
+
+	// A stopped server can be started again.
+	if err := s.Start([]lsp.ServerOption{lsp.OptConn(fake.Dial)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lsp.Initialize(s, []string{"/src"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(fake.ReceivedMethod("initialize")); n != 2 {
+		t.Errorf("got %d initialize requests, want 2", n)
+	}
+}
+
+func TestCrashOn(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.CrashOn("textDocument/references")
+
+	s := startServer(t, fake)
+
+	if _, err := lsp.TextDocumentReferences(s, "/src/main.c", 0, 0, true); err != lsp.ErrStopped {
+		t.Fatalf("got error %v, want %v", err, lsp.ErrStopped)
+	}
+
+	waitStopped(t, s)
+}
+
+func TestHangTimeout(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.Hang("textDocument/definition")
+
+	s := startServer(t, fake, lsp.OptTimeout(50*time.Millisecond))
+
+	if _, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The server still answers other requests.
+	fake.References(mainLocation)
+
+	if _, err := lsp.TextDocumentReferences(s, "/src/main.c", 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHangStop(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.Hang("textDocument/definition")
+	fake.References(mainLocation)
+
+	s := startServer(t, fake)
+
+	hung := make(chan error)
+
+	go func() {
+		_, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0)
+		hung <- err
+	}()
+
+	// Wait until the server has the request.
+	for i := 0; len(fake.ReceivedMethod("textDocument/definition")) == 0; i++ {
+		if i == 100 {
+			t.Fatal("the server didn't receive the request")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A hung request doesn't block other requests or notifications.
+	if _, err := lsp.TextDocumentReferences(s, "/src/main.c", 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lsp.TextDocumentDidClose(s, "/src/main.c"); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop is blocked by a hung request")
+	}
+
+	select {
+	case err := <-hung:
+		if err != lsp.ErrStopped {
+			t.Errorf("got error %v, want %v", err, lsp.ErrStopped)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hung request didn't fail when the server stopped")
+	}
+}
+
+func TestPositionEncoding(t *testing.T) {
+	tests := []struct {
+		result lsp.InitializeResult
+		want   lsp.PositionEncoding
+	}{
+		{lsp.InitializeResult{}, lsp.PositionEncodingUTF16},
+		{lsp.InitializeResult{Capabilities: lsp.ServerCapabilities{PositionEncoding: lsp.PositionEncodingUTF8}}, lsp.PositionEncodingUTF8},
+		{lsp.InitializeResult{OffsetEncoding: lsp.PositionEncodingUTF32}, lsp.PositionEncodingUTF32},
+	}
+
+	for _, tt := range tests {
+		fake := lsptest.NewServer()
+		fake.Initialize(tt.result)
+
+		s := startServer(t, fake)
+
+		if got := s.PositionEncoding(); got != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.result, got, tt.want)
+		}
+	}
+}
+
+func TestWaitIdle(t *testing.T) {
+	const quiet = 20 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		begin func(fake *lsptest.Server) error
+		end   func(fake *lsptest.Server) error
+	}{{
+		name: "work done",
+		begin: func(fake *lsptest.Server) error {
+			return fake.Progress("index", lsp.WorkDoneProgress{Kind: "begin", Title: "indexing"})
+		},
+		end: func(fake *lsptest.Server) error {
+			return fake.Progress("index", lsp.WorkDoneProgress{Kind: "end"})
+		},
+	}, {
+		name: "cquery",
+		begin: func(fake *lsptest.Server) error {
+			return fake.CqueryProgress(cquery.Progress{IndexRequestCount: 3, ActiveThreads: 1})
+		},
+		end: func(fake *lsptest.Server) error {
+			return fake.CqueryProgress(cquery.Progress{})
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := lsptest.NewServer()
+			fake.Definition(mainLocation)
+
+			s := startServer(t, fake)
+
+			// A server that reports nothing is idle once it
+			// has been quiet.
+			if !s.WaitIdle(quiet, time.Second) {
+				t.Fatal("idle server is busy")
+			}
+
+			if err := tt.begin(fake); err != nil {
+				t.Fatal(err)
+			}
+
+			// Notifications are handled in order, so once this
+			// returns, the progress has been seen.
+			if _, err := lsp.TextDocumentDefinition(s, "/src/main.c", 0, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			if s.WaitIdle(quiet, 5*quiet) {
+				t.Fatal("busy server is idle")
+			}
+
+			if err := tt.end(fake); err != nil {
+				t.Fatal(err)
+			}
+
+			if !s.WaitIdle(quiet, time.Second) {
+				t.Fatal("server is still busy after its work ended")
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/glob"
+)
+
+// notifier reports changes to the files in a set of directory trees.
+// It is implemented for each platform that supports file watching.
+type notifier interface {
+	// Add watches dir and all its subdirectories.
+	Add(dir string) error
+
+	// Close stops watching.
+	Close() error
+}
+
+// watchPattern is a glob pattern that the server registered with a
+// "workspace/didChangeWatchedFiles" registration.
+type watchPattern struct {
+	glob *glob.Glob
+
+	// base, if not empty, is the directory that the pattern is
+	// relative to.
+	base string
+
+	kind WatchKind
+}
+
+func (p *watchPattern) match(path string, change FileChangeType) bool {
+	kind := p.kind
+	if kind == 0 {
+		kind = WatchKindCreate | WatchKindChange | WatchKindDelete
+	}
+
+	switch change {
+	case FileChangeTypeCreated:
+		if kind&WatchKindCreate == 0 {
+			return false
+		}
+	case FileChangeTypeChanged:
+		if kind&WatchKindChange == 0 {
+			return false
+		}
+	case FileChangeTypeDeleted:
+		if kind&WatchKindDelete == 0 {
+			return false
+		}
+	}
+
+	if p.base != "" {
+		rel, err := filepath.Rel(p.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return false
+		}
+
+		path = rel
+	}
+
+	return p.glob.Match(path)
+}
+
+// parseWatchPattern parses the glob pattern of a FileSystemWatcher.
+func parseWatchPattern(w FileSystemWatcher) (*watchPattern, error) {
+	var pattern string
+	var base string
+
+	if err := json.Unmarshal(w.GlobPattern, &pattern); err != nil {
+		var rel RelativePattern
+
+		if err := json.Unmarshal(w.GlobPattern, &rel); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %s", string(w.GlobPattern))
+		}
+
+		// The base is either a URI or a WorkspaceFolder.
+		var uri string
+
+		if err := json.Unmarshal(rel.BaseURI, &uri); err != nil {
+			var folder WorkspaceFolder
+
+			if err := json.Unmarshal(rel.BaseURI, &folder); err != nil {
+				return nil, fmt.Errorf("invalid base URI %s", string(rel.BaseURI))
+			}
+
+			uri = folder.URI
+		}
+
+		if base, err = URIToPath(uri); err != nil {
+			return nil, err
+		}
+
+		pattern = rel.Pattern
+	}
+
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &watchPattern{glob: g, base: base, kind: w.Kind}, nil
+}
+
+// watcher watches the workspace roots for changes to the files that
+// match the patterns the server registered, and sends the changes in
+// a "workspace/didChangeWatchedFiles" notification once no further
+// changes have happened for the debounce delay. This coalesces the
+// bursts of changes from a branch switch or a build.
+type watcher struct {
+	srv   *Server
+	delay time.Duration
+
+	// paths is a copy of the server's path mappings, since the
+	// watcher can't take the Server lock.
+	paths PathMap
+
+	lock     sync.Mutex
+	notifier notifier
+	roots    []string
+	patterns map[string][]*watchPattern
+	changes  map[string]FileChangeType
+	timer    *time.Timer
+}
+
+func newWatcher(s *Server, delay time.Duration, paths PathMap) *watcher {
+	return &watcher{
+		srv:      s,
+		delay:    delay,
+		paths:    paths,
+		patterns: map[string][]*watchPattern{},
+		changes:  map[string]FileChangeType{},
+	}
+}
+
+// addRoots adds workspace roots to watch.
+func (w *watcher) addRoots(dirs []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, d := range dirs {
+		w.roots = append(w.roots, d)
+
+		if w.notifier != nil {
+			w.watch(d)
+		}
+	}
+}
+
+// watch starts watching dir. Walking a large tree takes a while, so
+// it happens in the background. The lock must be held.
+func (w *watcher) watch(dir string) {
+	go func(n notifier) {
+		if err := n.Add(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to watch %s: %s\n", dir, err)
+		}
+	}(w.notifier)
+}
+
+// register adds the watchers in a "workspace/didChangeWatchedFiles"
+// registration. Watching starts with the first registration, since
+// until then the server is not interested in any files.
+func (w *watcher) register(id string, opts *DidChangeWatchedFilesRegistrationOptions) error {
+	var patterns []*watchPattern
+
+	for _, fsw := range opts.Watchers {
+		p, err := parseWatchPattern(fsw)
+		if err != nil {
+			return err
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.patterns[id] = patterns
+
+	if w.notifier == nil {
+		n, err := newNotifier(w.changed)
+		if err != nil {
+			return err
+		}
+
+		w.notifier = n
+
+		for _, d := range w.roots {
+			w.watch(d)
+		}
+	}
+
+	return nil
+}
+
+// unregister removes the watchers in a registration.
+func (w *watcher) unregister(id string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.patterns, id)
+}
+
+// match returns true if any registered pattern matches the change.
+// The patterns are for the server's file system, so path is mapped
+// to that first. The lock must be held.
+func (w *watcher) match(path string, change FileChangeType) bool {
+	path = w.paths.ToServer(path)
+
+	for _, patterns := range w.patterns {
+		for _, p := range patterns {
+			if p.match(path, change) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// changed records a change to the file at path if any registered
+// pattern matches it, and restarts the debounce timer.
+func (w *watcher) changed(path string, change FileChangeType) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.match(path, change) {
+		return
+	}
+
+	uri := w.paths.serverURI(path)
+
+	// Coalesce the changes to each file, so that the server
+	// sees the net effect of the burst.
+	switch prev, ok := w.changes[uri]; {
+	case !ok:
+		w.changes[uri] = change
+	case prev == FileChangeTypeCreated && change == FileChangeTypeChanged:
+	case prev == FileChangeTypeDeleted && change == FileChangeTypeCreated:
+		w.changes[uri] = FileChangeTypeChanged
+	default:
+		w.changes[uri] = change
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	w.timer = time.AfterFunc(w.delay, w.flush)
+}
+
+// flush sends the pending changes to the server.
+func (w *watcher) flush() {
+	w.lock.Lock()
+
+	params := DidChangeWatchedFilesParams{
+		Changes: make([]FileEvent, 0, len(w.changes)),
+	}
+
+	for uri, change := range w.changes {
+		params.Changes = append(params.Changes, FileEvent{URI: uri, Type: change})
+	}
+
+	w.changes = map[string]FileChangeType{}
+	w.timer = nil
+
+	w.lock.Unlock()
+
+	if len(params.Changes) == 0 {
+		return
+	}
+
+	sort.Slice(params.Changes, func(i, j int) bool {
+		return params.Changes[i].URI < params.Changes[j].URI
+	})
+
+	// Don't hold the lock here. Notify takes the Server lock, which
+	// is held while the Server closes the watcher, and a server that
+	// isn't reading would block the notifier until the send is done.
+	err := w.srv.Notify(context.Background(), "workspace/didChangeWatchedFiles", &params)
+	if err != nil && err != ErrStopped {
+		fmt.Fprintf(os.Stderr, "failed to send file changes: %s\n", err)
+	}
+}
+
+// close stops watching and discards any pending changes.
+func (w *watcher) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	if w.notifier != nil {
+		w.notifier.Close()
+		w.notifier = nil
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package lsp
+
+import "errors"
+
+// watchSupported is true if file watching is implemented on this
+// platform.
+const watchSupported = false
+
+func newNotifier(changed func(path string, change FileChangeType)) (notifier, error) {
+	return nil, errors.New("file watching is not supported on this platform")
+}
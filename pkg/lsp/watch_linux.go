@@ -0,0 +1,351 @@
+// +build linux
+
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchSupported is true if file watching is implemented on this
+// platform.
+const watchSupported = true
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// inotify is a notifier that uses a Linux inotify instance. Since
+// inotify is not recursive, it watches each subdirectory separately,
+// and adds new subdirectories as they are created.
+type inotify struct {
+	fd      int
+	file    *os.File
+	changed func(path string, change FileChangeType)
+
+	lock  sync.Mutex
+	roots []string
+	dirs  map[int]string
+
+	// files holds the names of the files in each directory, so
+	// that the files in a directory that is deleted or moved out
+	// of the tree can be reported as deleted.
+	files map[string]map[string]bool
+
+	// failed is set once a directory could not be watched, so that
+	// only the first failure is logged.
+	failed bool
+
+	// rescanning is set while the roots are rescanned after the
+	// event queue overflowed, and rescanAgain is set if it
+	// overflowed again during the rescan.
+	rescanning  bool
+	rescanAgain bool
+}
+
+func newNotifier(changed func(path string, change FileChangeType)) (notifier, error) {
+	n, err := newInotify(changed)
+	if err != nil {
+		return nil, err
+	}
+
+	go n.read()
+
+	return n, nil
+}
+
+// newInotify returns an inotify that doesn't read its events yet.
+func newInotify(changed func(path string, change FileChangeType)) (*inotify, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inotify{
+		fd: fd,
+		// Since the descriptor is non-blocking, the os.File uses
+		// the runtime poller, and Close interrupts a pending Read.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changed: changed,
+		dirs:    map[int]string{},
+		files:   map[string]map[string]bool{},
+	}, nil
+}
+
+// Add watches dir and all its subdirectories, except for hidden
+// directories such as ".git" and the server index caches.
+func (n *inotify) Add(dir string) error {
+	n.lock.Lock()
+	n.roots = append(n.roots, dir)
+	n.lock.Unlock()
+
+	n.add(dir, false)
+	return nil
+}
+
+// add watches the tree at dir. If report is true, the files in the
+// tree are reported as created, since they might have been created
+// before the watch was in place. Directories that can't be watched
+// are skipped, since one unreadable directory, or running out of
+// watches, shouldn't stop us watching the rest of the tree.
+func (n *inotify) add(dir string, report bool) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip anything that disappeared or that we
+			// are not allowed to read.
+			return nil
+		}
+
+		if !info.IsDir() {
+			n.addFile(path)
+
+			if report {
+				n.changed(path, FileChangeTypeCreated)
+			}
+			return nil
+		}
+
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		wd, err := unix.InotifyAddWatch(n.fd, path, inotifyMask)
+		if err != nil {
+			n.watchFailed(path, err)
+			return nil
+		}
+
+		n.lock.Lock()
+		n.dirs[wd] = path
+		n.lock.Unlock()
+
+		return nil
+	})
+}
+
+// watchFailed logs the first directory that could not be watched.
+// The usual cause is running out of watches, in which case every
+// following directory fails too.
+func (n *inotify) watchFailed(path string, err error) {
+	n.lock.Lock()
+	first := !n.failed
+	n.failed = true
+	n.lock.Unlock()
+
+	if first {
+		fmt.Fprintf(os.Stderr, "failed to watch %s: %s (further failures are not logged)\n", path, err)
+	}
+}
+
+// addFile records the file at path.
+func (n *inotify) addFile(path string) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.files[dir] == nil {
+		n.files[dir] = map[string]bool{}
+	}
+
+	n.files[dir][name] = true
+}
+
+// removeFile forgets the file at path.
+func (n *inotify) removeFile(path string) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.files[dir], name)
+}
+
+// remove stops watching the tree at dir, which was deleted or moved
+// away, and reports the files that were in it as deleted. A tree that
+// was moved within the workspace is added again by the event for its
+// new name.
+func (n *inotify) remove(dir string) {
+	var deleted []string
+
+	inTree := func(path string) bool {
+		return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+	}
+
+	n.lock.Lock()
+
+	for wd, path := range n.dirs {
+		if inTree(path) {
+			// The watch is already gone if the directory
+			// was deleted, so ignore any error.
+			unix.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.dirs, wd)
+		}
+	}
+
+	for path, names := range n.files {
+		if !inTree(path) {
+			continue
+		}
+
+		for name := range names {
+			deleted = append(deleted, filepath.Join(path, name))
+		}
+
+		delete(n.files, path)
+	}
+
+	n.lock.Unlock()
+
+	for _, path := range deleted {
+		n.changed(path, FileChangeTypeDeleted)
+	}
+}
+
+// overflow starts a rescan of the roots, since the kernel dropped
+// events when its queue overflowed.
+func (n *inotify) overflow() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.rescanning {
+		n.rescanAgain = true
+		return
+	}
+
+	n.rescanning = true
+
+	go func() {
+		for {
+			n.rescan()
+
+			n.lock.Lock()
+			again := n.rescanAgain
+			n.rescanAgain = false
+			n.rescanning = again
+			n.lock.Unlock()
+
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+// rescan walks the roots again, adding watches for any directories
+// that were missed. Since we don't know what changed, new files are
+// reported as created, missing files as deleted, and every other file
+// as changed.
+func (n *inotify) rescan() {
+	n.lock.Lock()
+	roots := append([]string{}, n.roots...)
+	old := n.files
+	n.files = map[string]map[string]bool{}
+	n.lock.Unlock()
+
+	for _, r := range roots {
+		n.add(r, false)
+	}
+
+	n.lock.Lock()
+	cur := n.files
+	n.lock.Unlock()
+
+	for dir, names := range cur {
+		for name := range names {
+			if old[dir][name] {
+				n.changed(filepath.Join(dir, name), FileChangeTypeChanged)
+			} else {
+				n.changed(filepath.Join(dir, name), FileChangeTypeCreated)
+			}
+		}
+	}
+
+	for dir, names := range old {
+		for name := range names {
+			if !cur[dir][name] {
+				n.changed(filepath.Join(dir, name), FileChangeTypeDeleted)
+			}
+		}
+	}
+}
+
+// Close stops watching.
+func (n *inotify) Close() error {
+	return n.file.Close()
+}
+
+func (n *inotify) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+
+	for {
+		size, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= size; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += unix.SizeofInotifyEvent
+
+			name := string(bytes.TrimRight(buf[off:off+int(ev.Len)], "\x00"))
+			off += int(ev.Len)
+
+			n.event(int(ev.Wd), ev.Mask, name)
+		}
+	}
+}
+
+func (n *inotify) event(wd int, mask uint32, name string) {
+	// The overflow event has no watch descriptor.
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		n.overflow()
+		return
+	}
+
+	n.lock.Lock()
+
+	dir, ok := n.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(n.dirs, wd)
+	}
+
+	n.lock.Unlock()
+
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+
+	if mask&unix.IN_ISDIR != 0 {
+		switch {
+		case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			if !strings.HasPrefix(name, ".") {
+				n.add(path, true)
+			}
+		case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+			n.remove(path)
+		}
+		return
+	}
+
+	switch {
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		n.addFile(path)
+		n.changed(path, FileChangeTypeCreated)
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		n.removeFile(path)
+		n.changed(path, FileChangeTypeDeleted)
+	case mask&unix.IN_MODIFY != 0:
+		n.changed(path, FileChangeTypeChanged)
+	}
+}
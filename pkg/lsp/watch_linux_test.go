@@ -0,0 +1,167 @@
+//go:build linux
+// +build linux
+
+package lsp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// changeLog collects the changes that a notifier reports.
+type changeLog struct {
+	lock    sync.Mutex
+	changes map[string]FileChangeType
+}
+
+func (c *changeLog) changed(path string, change FileChangeType) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.changes[path] = change
+}
+
+// wait waits until the changes include want.
+func (c *changeLog) wait(t *testing.T, want map[string]FileChangeType) {
+	t.Helper()
+
+	for i := 0; ; i++ {
+		c.lock.Lock()
+
+		missing := ""
+		for path, change := range want {
+			if c.changes[path] != change {
+				missing = path
+			}
+		}
+
+		c.lock.Unlock()
+
+		if missing == "" {
+			return
+		}
+
+		if i == 200 {
+			t.Fatalf("no change %d for %s", want[missing], missing)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// makeTree creates the given files under a new temporary directory.
+func makeTree(t *testing.T, files ...string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestWatchDirectoryMovedOut(t *testing.T) {
+	dir := makeTree(t, "src/main.c", "src/lib/lib.c", "src/lib/lib.h")
+	out := makeTree(t)
+
+	log := &changeLog{changes: map[string]FileChangeType{}}
+
+	n, err := newNotifier(log.changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer n.Close()
+
+	if err := n.Add(filepath.Join(dir, "src")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(filepath.Join(dir, "src/lib"), filepath.Join(out, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	log.wait(t, map[string]FileChangeType{
+		filepath.Join(dir, "src/lib/lib.c"): FileChangeTypeDeleted,
+		filepath.Join(dir, "src/lib/lib.h"): FileChangeTypeDeleted,
+	})
+
+	// The moved directory is no longer watched.
+	if err := ioutil.WriteFile(filepath.Join(out, "lib/new.c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(filepath.Join(dir, "src/main.c"), filepath.Join(dir, "src/main2.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	log.wait(t, map[string]FileChangeType{
+		filepath.Join(dir, "src/main2.c"): FileChangeTypeCreated,
+	})
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	for path := range log.changes {
+		if filepath.Base(path) == "new.c" {
+			t.Errorf("got a change for %s, which is outside the tree", path)
+		}
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	dir := makeTree(t, "main.c", "old.c", "lib/lib.c")
+
+	log := &changeLog{changes: map[string]FileChangeType{}}
+
+	// Don't read the events, so that the changes are only seen
+	// by the rescan.
+	n, err := newInotify(log.changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer n.Close()
+
+	if err := n.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "old.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib/new.c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n.event(-1, unix.IN_Q_OVERFLOW, "")
+
+	log.wait(t, map[string]FileChangeType{
+		filepath.Join(dir, "main.c"):    FileChangeTypeChanged,
+		filepath.Join(dir, "lib/lib.c"): FileChangeTypeChanged,
+		filepath.Join(dir, "lib/new.c"): FileChangeTypeCreated,
+		filepath.Join(dir, "old.c"):     FileChangeTypeDeleted,
+	})
+}
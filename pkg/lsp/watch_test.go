@@ -0,0 +1,170 @@
+// +build linux
+
+package lsp_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+)
+
+// fileChanges returns the "workspace/didChangeWatchedFiles"
+// notifications that fake has received.
+func fileChanges(t *testing.T, fake *lsptest.Server) []lsp.DidChangeWatchedFilesParams {
+	t.Helper()
+
+	var changes []lsp.DidChangeWatchedFilesParams
+
+	for _, raw := range fake.ReceivedMethod("workspace/didChangeWatchedFiles") {
+		var params lsp.DidChangeWatchedFilesParams
+
+		if err := json.Unmarshal(raw, &params); err != nil {
+			t.Fatal(err)
+		}
+
+		changes = append(changes, params)
+	}
+
+	return changes
+}
+
+// waitChanges waits until fake has received n file change
+// notifications, calling poke every half second. Since the changes
+// are debounced, poking more often could delay them indefinitely.
+func waitChanges(t *testing.T, fake *lsptest.Server, n int, poke func()) []lsp.DidChangeWatchedFilesParams {
+	t.Helper()
+
+	for i := 0; ; i++ {
+		if changes := fileChanges(t, fake); len(changes) >= n {
+			return changes
+		}
+
+		if i == 500 {
+			t.Fatalf("got %d file change notifications, want %d", len(fileChanges(t, fake)), n)
+		}
+
+		if i%50 == 0 {
+			poke()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	write := func(name string) {
+		t.Helper()
+
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("probe.c")
+	write("include/old.h")
+
+	const delay = 200 * time.Millisecond
+
+	fake := lsptest.NewServer()
+	s := startServer(t, fake, lsp.OptWatch(delay))
+
+	if err := lsp.WorkspaceDidChangeWorkspaceFolders(s, []string{dir}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	watchers := []lsp.FileSystemWatcher{
+		{GlobPattern: json.RawMessage(`"**/*.c"`)},
+		{
+			GlobPattern: json.RawMessage(`{"baseUri": "` + lsp.FileToURI(filepath.Join(dir, "include")) + `", "pattern": "*.h"}`),
+			Kind:        lsp.WatchKindCreate,
+		},
+	}
+
+	opts, err := json.Marshal(&lsp.DidChangeWatchedFilesRegistrationOptions{Watchers: watchers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration := lsp.RegistrationParams{
+		Registrations: []lsp.Registration{{
+			ID:              "watch",
+			Method:          "workspace/didChangeWatchedFiles",
+			RegisterOptions: opts,
+		}},
+	}
+
+	if err := fake.Call("client/registerCapability", &registration, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The roots are watched in the background, so change a file
+	// until the watch is in place.
+	waitChanges(t, fake, 1, func() { write("probe.c") })
+
+	// A burst of changes is sent in one notification, with the
+	// changes to each file coalesced.
+	write("a.c")
+	write("a.c")
+	write("notes.txt")
+	write("include/new.h")
+	write("include/old.h")
+	write("gen/gen.c")
+
+	if err := os.Remove(filepath.Join(dir, "probe.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := waitChanges(t, fake, 2, func() {})
+
+	want := lsp.DidChangeWatchedFilesParams{
+		Changes: []lsp.FileEvent{
+			{URI: lsp.FileToURI(filepath.Join(dir, "a.c")), Type: lsp.FileChangeTypeCreated},
+			{URI: lsp.FileToURI(filepath.Join(dir, "gen/gen.c")), Type: lsp.FileChangeTypeCreated},
+			{URI: lsp.FileToURI(filepath.Join(dir, "include/new.h")), Type: lsp.FileChangeTypeCreated},
+			{URI: lsp.FileToURI(filepath.Join(dir, "probe.c")), Type: lsp.FileChangeTypeDeleted},
+		},
+	}
+
+	if !reflect.DeepEqual(changes[1], want) {
+		t.Errorf("got changes %+v, want %+v", changes[1], want)
+	}
+
+	unregistration := lsp.UnregistrationParams{
+		Unregisterations: []lsp.Unregistration{{
+			ID:     "watch",
+			Method: "workspace/didChangeWatchedFiles",
+		}},
+	}
+
+	if err := fake.Call("client/unregisterCapability", &unregistration, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes are no longer sent once the watchers are gone.
+	write("b.c")
+	time.Sleep(2 * delay)
+
+	if changes := fileChanges(t, fake); len(changes) != 2 {
+		t.Errorf("got %d file change notifications after unregistering, want 2", len(changes))
+	}
+}
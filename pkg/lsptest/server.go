@@ -0,0 +1,461 @@
+// Package lsptest provides a scriptable, in-process fake language
+// server for testing LSP clients without a real language server.
+package lsptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// HandlerFunc answers a request with the given parameters. The result
+// is marshaled to JSON. If the error is a *jsonrpc2.Error, it is sent
+// as is, otherwise it is sent as an internal error.
+type HandlerFunc func(params json.RawMessage) (interface{}, error)
+
+// Message is a request or notification received by the Server.
+type Message struct {
+	Method string
+	Params json.RawMessage
+	Notif  bool
+}
+
+// Server is a fake language server. Clients connect to it with Dial,
+// which can be passed to lsp.OptConn. A Server can accept any number
+// of connections, and answers requests on all of them using the same
+// handlers.
+type Server struct {
+	lock     sync.Mutex
+	handlers map[string]HandlerFunc
+	hang     map[string]bool
+	crash    map[string]bool
+	conns    map[*jsonrpc2.Conn]struct{}
+	received []Message
+
+	// incoming and outgoing hold the calls for each call
+	// hierarchy item, by name.
+	incoming map[string][]lsp.CallHierarchyIncomingCall
+	outgoing map[string][]lsp.CallHierarchyOutgoingCall
+}
+
+// NewServer returns a Server that answers "initialize" and "shutdown".
+// All other requests fail with a MethodNotFound error until a handler
+// is registered for them.
+func NewServer() *Server {
+	s := &Server{
+		handlers: map[string]HandlerFunc{},
+		hang:     map[string]bool{},
+		crash:    map[string]bool{},
+		conns:    map[*jsonrpc2.Conn]struct{}{},
+		incoming: map[string][]lsp.CallHierarchyIncomingCall{},
+		outgoing: map[string][]lsp.CallHierarchyOutgoingCall{},
+	}
+
+	s.Initialize(lsp.InitializeResult{})
+
+	s.Handle("shutdown", func(json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+
+	return s
+}
+
+// Handle registers the handler for requests with the given method,
+// replacing any existing handler.
+func (s *Server) Handle(method string, h HandlerFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.handlers[method] = h
+}
+
+// Reply answers every request with the given method with result.
+func (s *Server) Reply(method string, result interface{}) {
+	s.Handle(method, func(json.RawMessage) (interface{}, error) {
+		return result, nil
+	})
+}
+
+// Fail answers every request with the given method with an error.
+func (s *Server) Fail(method string, code int64, message string) {
+	s.Handle(method, func(json.RawMessage) (interface{}, error) {
+		return nil, &jsonrpc2.Error{Code: code, Message: message}
+	})
+}
+
+// Initialize answers the "initialize" request with result.
+func (s *Server) Initialize(result lsp.InitializeResult) {
+	s.Reply("initialize", result)
+}
+
+// Definition answers "textDocument/definition" with the given locations.
+func (s *Server) Definition(loc ...lsp.Location) {
+	s.Reply("textDocument/definition", locations(loc))
+}
+
+// Implementation answers "textDocument/implementation" with the
+// given locations.
+func (s *Server) Implementation(loc ...lsp.Location) {
+	s.Reply("textDocument/implementation", locations(loc))
+}
+
+// TypeDefinition answers "textDocument/typeDefinition" with the
+// given locations.
+func (s *Server) TypeDefinition(loc ...lsp.Location) {
+	s.Reply("textDocument/typeDefinition", locations(loc))
+}
+
+// References answers "textDocument/references" with the given locations.
+func (s *Server) References(loc ...lsp.Location) {
+	s.Reply("textDocument/references", locations(loc))
+}
+
+// DocumentSymbol answers "textDocument/documentSymbol" with the
+// symbols for the requested document, taken from the given symbols.
+func (s *Server) DocumentSymbol(syms ...lsp.SymbolInformation) {
+	s.Handle("textDocument/documentSymbol", func(params json.RawMessage) (interface{}, error) {
+		var p lsp.DocumentSymbolParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		result := []lsp.SymbolInformation{}
+		for _, sym := range syms {
+			if sym.Location.URI == p.TextDocument.URI {
+				result = append(result, sym)
+			}
+		}
+
+		return result, nil
+	})
+}
+
+// WorkspaceSymbol answers "workspace/symbol" with the given symbols
+// whose names contain the query.
+func (s *Server) WorkspaceSymbol(syms ...lsp.SymbolInformation) {
+	s.Handle("workspace/symbol", func(params json.RawMessage) (interface{}, error) {
+		var p lsp.WorkspaceSymbolParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		result := []lsp.SymbolInformation{}
+		for _, sym := range syms {
+			if strings.Contains(sym.Name, p.Query) {
+				result = append(result, sym)
+			}
+		}
+
+		return result, nil
+	})
+}
+
+// CallHierarchy answers "$cquery/callHierarchy" requests with the
+// callers or callees hierarchy, depending on the request.
+func (s *Server) CallHierarchy(callers *cquery.CallHierarchy, callees *cquery.CallHierarchy) {
+	s.Handle("$cquery/callHierarchy", func(params json.RawMessage) (interface{}, error) {
+		var p cquery.CallHierarchyParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		if p.Callee {
+			return callees, nil
+		}
+
+		return callers, nil
+	})
+}
+
+// Hang stops the server answering requests with the given method.
+// Requests are still received, but never get a response.
+func (s *Server) Hang(method string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.hang[method] = true
+}
+
+// CrashOn makes the server drop all its connections when it receives
+// a request or notification with the given method.
+func (s *Server) CrashOn(method string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.crash[method] = true
+}
+
+// PrepareCallHierarchy answers "textDocument/prepareCallHierarchy"
+// with the given items.
+func (s *Server) PrepareCallHierarchy(items ...lsp.CallHierarchyItem) {
+	if items == nil {
+		items = []lsp.CallHierarchyItem{}
+	}
+
+	s.Reply("textDocument/prepareCallHierarchy", items)
+}
+
+// IncomingCalls answers "callHierarchy/incomingCalls" for the item
+// with the given name with calls. Items without calls have none.
+func (s *Server) IncomingCalls(name string, calls ...lsp.CallHierarchyIncomingCall) {
+	s.lock.Lock()
+	s.incoming[name] = calls
+	s.lock.Unlock()
+
+	s.Handle("callHierarchy/incomingCalls", func(params json.RawMessage) (interface{}, error) {
+		var p lsp.CallHierarchyCallsParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		return append([]lsp.CallHierarchyIncomingCall{}, s.incoming[p.Item.Name]...), nil
+	})
+}
+
+// OutgoingCalls answers "callHierarchy/outgoingCalls" for the item
+// with the given name with calls. Items without calls have none.
+func (s *Server) OutgoingCalls(name string, calls ...lsp.CallHierarchyOutgoingCall) {
+	s.lock.Lock()
+	s.outgoing[name] = calls
+	s.lock.Unlock()
+
+	s.Handle("callHierarchy/outgoingCalls", func(params json.RawMessage) (interface{}, error) {
+		var p lsp.CallHierarchyCallsParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		return append([]lsp.CallHierarchyOutgoingCall{}, s.outgoing[p.Item.Name]...), nil
+	})
+}
+
+// Crash drops all the connections to the server, as if the server
+// process had died.
+func (s *Server) Crash() {
+	s.lock.Lock()
+	conns := s.connections()
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Notify sends a notification to all connected clients.
+func (s *Server) Notify(method string, params interface{}) error {
+	s.lock.Lock()
+	conns := s.connections()
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		if err := c.Notify(context.Background(), method, params); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Call sends a request to each connected client, and waits for the
+// replies. The result of the last reply is stored in result.
+func (s *Server) Call(method string, params interface{}, result interface{}) error {
+	s.lock.Lock()
+	conns := s.connections()
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		if err := c.Call(context.Background(), method, params, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterWatchers asks the connected clients to watch the files that
+// match the given glob patterns, with a "client/registerCapability"
+// request.
+func (s *Server) RegisterWatchers(id string, patterns ...string) error {
+	opts := lsp.DidChangeWatchedFilesRegistrationOptions{}
+
+	for _, p := range patterns {
+		glob, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+
+		opts.Watchers = append(opts.Watchers, lsp.FileSystemWatcher{GlobPattern: glob})
+	}
+
+	raw, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	return s.Call("client/registerCapability", lsp.RegistrationParams{
+		Registrations: []lsp.Registration{
+			{ID: id, Method: "workspace/didChangeWatchedFiles", RegisterOptions: raw},
+		},
+	}, nil)
+}
+
+// Progress sends a "$/progress" notification with the given token
+// and value to all connected clients.
+func (s *Server) Progress(token interface{}, value interface{}) error {
+	return s.Notify("$/progress", map[string]interface{}{
+		"token": token,
+		"value": value,
+	})
+}
+
+// CqueryProgress sends a "$cquery/progress" notification to all
+// connected clients.
+func (s *Server) CqueryProgress(p cquery.Progress) error {
+	return s.Notify("$cquery/progress", p)
+}
+
+// Received returns the requests and notifications received by the
+// server, in the order they were received.
+func (s *Server) Received() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Message{}, s.received...)
+}
+
+// ReceivedMethod returns the parameters of each request or notification
+// received with the given method.
+func (s *Server) ReceivedMethod(method string) []json.RawMessage {
+	var params []json.RawMessage
+
+	for _, m := range s.Received() {
+		if m.Method == method {
+			params = append(params, m.Params)
+		}
+	}
+
+	return params
+}
+
+// Dial connects a new client to the server, and returns the client
+// end of the connection. Its signature matches lsp.OptConn.
+func (s *Server) Dial() (io.ReadWriteCloser, error) {
+	client, srv := net.Pipe()
+
+	conn := jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(srv, jsonrpc2.VSCodeObjectCodec{}),
+		handler{s})
+
+	s.lock.Lock()
+	s.conns[conn] = struct{}{}
+	s.lock.Unlock()
+
+	go func() {
+		<-conn.DisconnectNotify()
+
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	return client, nil
+}
+
+// connections returns the current connections. The lock must be held.
+func (s *Server) connections() []*jsonrpc2.Conn {
+	conns := make([]*jsonrpc2.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// handler adapts a Server to jsonrpc2.Handler.
+type handler struct {
+	s *Server
+}
+
+func (h handler) Handle(ctx context.Context, c *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	h.s.handle(ctx, c, req)
+}
+
+func (s *Server) handle(ctx context.Context, c *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	m := Message{
+		Method: req.Method,
+		Notif:  req.Notif,
+	}
+
+	if req.Params != nil {
+		m.Params = append(json.RawMessage{}, *req.Params...)
+	}
+
+	s.lock.Lock()
+	s.received = append(s.received, m)
+	h := s.handlers[req.Method]
+	hang := s.hang[req.Method]
+	crash := s.crash[req.Method]
+	s.lock.Unlock()
+
+	if crash {
+		s.Crash()
+		return
+	}
+
+	if req.Notif || hang {
+		return
+	}
+
+	if h == nil {
+		c.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeMethodNotFound,
+			Message: fmt.Sprintf("method not found: %s", req.Method),
+		})
+		return
+	}
+
+	result, err := h(m.Params)
+
+	switch err := err.(type) {
+	case nil:
+		c.Reply(ctx, req.ID, result)
+	case *jsonrpc2.Error:
+		c.ReplyWithError(ctx, req.ID, err)
+	default:
+		c.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInternalError,
+			Message: err.Error(),
+		})
+	}
+}
+
+// locations makes sure that an empty result is sent as an empty
+// array rather than null.
+func locations(loc []lsp.Location) []lsp.Location {
+	if loc == nil {
+		return []lsp.Location{}
+	}
+
+	return loc
+}
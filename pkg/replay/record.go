@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Direction is the direction of a recorded JSON-RPC message.
+type Direction string
+
+const (
+	// Send is a message sent to the language server.
+	Send Direction = "send"
+
+	// Recv is a message received from the language server.
+	Recv Direction = "recv"
+)
+
+// Message is a single recorded JSON-RPC message. Exactly one of
+// Request and Response is set.
+type Message struct {
+	Server   string             `json:"server"`
+	Dir      Direction          `json:"dir"`
+	Request  *jsonrpc2.Request  `json:"request,omitempty"`
+	Response *jsonrpc2.Response `json:"response,omitempty"`
+}
+
+// Recorder writes the complete JSON-RPC messages exchanged with one
+// or more language servers as JSON lines. It is safe for concurrent
+// use.
+type Recorder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewRecorder returns a Recorder that writes to out.
+func NewRecorder(out io.Writer) *Recorder {
+	return &Recorder{
+		enc: json.NewEncoder(out),
+	}
+}
+
+func (r *Recorder) write(m *Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.enc.Encode(m)
+}
+
+// Server returns a ServerRecorder that records the messages exchanged
+// with the named language server.
+func (r *Recorder) Server(name string) *ServerRecorder {
+	return &ServerRecorder{
+		r:    r,
+		name: name,
+	}
+}
+
+// ServerRecorder records the messages exchanged with a single language
+// server. Its Send and Recv methods match the message hooks of
+// jsonrpc2.Conn.
+type ServerRecorder struct {
+	r    *Recorder
+	name string
+}
+
+// Send records a message sent to the server.
+func (s *ServerRecorder) Send(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	s.r.write(&Message{
+		Server:   s.name,
+		Dir:      Send,
+		Request:  req,
+		Response: resp,
+	})
+}
+
+// Recv records a message received from the server. For responses,
+// jsonrpc2 also passes the matching request, but we only record the
+// response since the request was already recorded when it was sent.
+func (s *ServerRecorder) Recv(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	if resp != nil {
+		req = nil
+	}
+
+	s.r.write(&Message{
+		Server:   s.name,
+		Dir:      Recv,
+		Request:  req,
+		Response: resp,
+	})
+}
@@ -0,0 +1,179 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Exchange is a recorded request together with its response.
+type Exchange struct {
+	Method   string
+	Params   *json.RawMessage
+	Response *jsonrpc2.Response
+}
+
+// Recording is a recorded session with one or more language servers.
+type Recording struct {
+	// Exchanges are the requests sent to each server, keyed by
+	// server name, in the order they were sent.
+	Exchanges map[string][]Exchange
+}
+
+// Load reads a recording written by a Recorder.
+func Load(in io.Reader) (*Recording, error) {
+	type key struct {
+		server string
+		id     jsonrpc2.ID
+	}
+
+	rec := &Recording{
+		Exchanges: map[string][]Exchange{},
+	}
+
+	pending := map[key]*jsonrpc2.Request{}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		switch {
+		case m.Dir == Send && m.Request != nil && !m.Request.Notif:
+			pending[key{m.Server, m.Request.ID}] = m.Request
+
+		case m.Dir == Recv && m.Response != nil:
+			k := key{m.Server, m.Response.ID}
+
+			req, ok := pending[k]
+			if !ok {
+				continue
+			}
+
+			delete(pending, k)
+
+			rec.Exchanges[m.Server] = append(rec.Exchanges[m.Server], Exchange{
+				Method:   req.Method,
+				Params:   req.Params,
+				Response: m.Response,
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// Dial starts a replay of the named server's recorded session, and
+// returns the client end of the connection to it. Each request the
+// client sends is answered with the recorded response to the first
+// unused request with the same method and parameters. If there is no
+// exact match, the first unused request with the same method is used,
+// since parameters such as process IDs change from run to run. A
+// request with no recorded response fails with a MethodNotFound error.
+// Notifications from the client are ignored, and no messages are
+// sent that the client did not ask for.
+func (r *Recording) Dial(server string) io.ReadWriteCloser {
+	client, srv := net.Pipe()
+
+	h := &handler{
+		exchanges: append([]Exchange{}, r.Exchanges[server]...),
+		used:      make([]bool, len(r.Exchanges[server])),
+	}
+
+	jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(srv, jsonrpc2.VSCodeObjectCodec{}),
+		h)
+
+	return client
+}
+
+type handler struct {
+	lock      sync.Mutex
+	exchanges []Exchange
+	used      []bool
+}
+
+// equalParams compares JSON parameters by value.
+func equalParams(a *json.RawMessage, b *json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	var va, vb interface{}
+
+	if json.Unmarshal(*a, &va) != nil || json.Unmarshal(*b, &vb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+// match returns the recorded response for the request, or nil.
+func (h *handler) match(req *jsonrpc2.Request) *jsonrpc2.Response {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	candidate := -1
+
+	for i, e := range h.exchanges {
+		if h.used[i] || e.Method != req.Method {
+			continue
+		}
+
+		if equalParams(e.Params, req.Params) {
+			candidate = i
+			break
+		}
+
+		if candidate < 0 {
+			candidate = i
+		}
+	}
+
+	if candidate < 0 {
+		return nil
+	}
+
+	h.used[candidate] = true
+	return h.exchanges[candidate].Response
+}
+
+func (h *handler) Handle(ctx context.Context, c *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if req.Notif {
+		return
+	}
+
+	recorded := h.match(req)
+	if recorded == nil {
+		c.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeMethodNotFound,
+			Message: fmt.Sprintf("no recorded response for %s", req.Method),
+		})
+		return
+	}
+
+	resp := *recorded
+	resp.ID = req.ID
+
+	c.SendResponse(ctx, &resp)
+}
@@ -0,0 +1,38 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Read reads all the events from a trace.
+func Read(in io.Reader) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(in)
+
+	// LSP messages can be large, but we only trace their metadata,
+	// so lines are short unless a log message is very long.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,250 @@
+package trace
+
+import (
+	"sort"
+	"time"
+)
+
+// Latency summarises a set of durations.
+type Latency struct {
+	P50 time.Duration
+	P95 time.Duration
+	Max time.Duration
+}
+
+// latency returns the Latency of the given durations.
+func latency(d []time.Duration) Latency {
+	if len(d) == 0 {
+		return Latency{}
+	}
+
+	sorted := append([]time.Duration{}, d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// Nearest-rank percentile.
+	rank := func(p int) time.Duration {
+		i := (p*len(sorted)+99)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+
+	return Latency{
+		P50: rank(50),
+		P95: rank(95),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// Stat summarises a group of queries or requests.
+type Stat struct {
+	// Name is the search type or method name.
+	Name string
+
+	// Count is the number of queries or requests.
+	Count int
+
+	// Errors counts the failures by error code. Queries have no
+	// error codes, so their failures are counted under 0.
+	Errors map[int64]int
+
+	// Unanswered is the number of requests that never got a response.
+	Unanswered int
+
+	Latency Latency
+
+	durations []time.Duration
+}
+
+func (s *Stat) add(d time.Duration, err *Error) {
+	s.Count++
+	s.durations = append(s.durations, d)
+
+	if err != nil {
+		if s.Errors == nil {
+			s.Errors = map[int64]int{}
+		}
+		s.Errors[err.Code]++
+	}
+}
+
+// Request is a JSON-RPC request matched with its response.
+type Request struct {
+	Server   string
+	ID       string
+	Method   string
+	Time     time.Time
+	Duration time.Duration
+	Error    *Error
+}
+
+// Summary summarises a trace.
+type Summary struct {
+	// Queries are the cscope query statistics, by search type.
+	Queries []*Stat
+
+	// Methods are the LSP request statistics, by method, for
+	// requests sent to the servers.
+	Methods []*Stat
+
+	// Notifications counts the LSP notifications by method, in
+	// both directions.
+	Notifications map[string]int
+
+	// SlowestQueries are the slowest cscope queries, slowest first.
+	SlowestQueries []Event
+
+	// SlowestRequests are the slowest LSP requests, slowest first.
+	SlowestRequests []Request
+}
+
+// Summarize matches requests to responses and summarises the latency
+// and errors of each cscope search type and LSP method. The slowest
+// n queries and requests are kept in the summary. A negative n is
+// treated as 0.
+func Summarize(events []Event, n int, searchName func(int) string) *Summary {
+	if n < 0 {
+		n = 0
+	}
+
+	type key struct {
+		server string
+		id     string
+	}
+
+	queries := map[string]*Stat{}
+	methods := map[string]*Stat{}
+	pending := map[key]*Event{}
+
+	var unanswered []*Event
+
+	sum := &Summary{
+		Notifications: map[string]int{},
+	}
+
+	var requests []Request
+
+	for i := range events {
+		e := &events[i]
+
+		switch e.Kind {
+		case KindQuery:
+			name := "unknown"
+			if e.Search != nil {
+				name = searchName(*e.Search)
+			}
+
+			if queries[name] == nil {
+				queries[name] = &Stat{Name: name}
+			}
+
+			queries[name].add(e.Duration, e.Error)
+			sum.SlowestQueries = append(sum.SlowestQueries, *e)
+
+		case KindNotification:
+			sum.Notifications[e.Method]++
+
+		case KindRequest:
+			// Only requests that we send have latency that
+			// matters to cscope queries.
+			if e.Dir != Send {
+				continue
+			}
+
+			// A restarted server is initialized again, and its
+			// request IDs start over. Requests to the previous
+			// instance that are still pending never got a
+			// response.
+			if e.Method == "initialize" {
+				for k, req := range pending {
+					if k.server == e.Server {
+						unanswered = append(unanswered, req)
+						delete(pending, k)
+					}
+				}
+			}
+
+			pending[key{e.Server, e.ID}] = e
+
+		case KindResponse:
+			if e.Dir != Recv {
+				continue
+			}
+
+			req, ok := pending[key{e.Server, e.ID}]
+			if !ok {
+				continue
+			}
+
+			delete(pending, key{e.Server, e.ID})
+
+			// Use the recorded latency if there is one, but
+			// fall back to the difference in timestamps.
+			d := e.Duration
+			if d == 0 {
+				d = e.Time.Sub(req.Time)
+			}
+
+			if methods[req.Method] == nil {
+				methods[req.Method] = &Stat{Name: req.Method}
+			}
+
+			methods[req.Method].add(d, e.Error)
+
+			requests = append(requests, Request{
+				Server:   e.Server,
+				ID:       e.ID,
+				Method:   req.Method,
+				Time:     req.Time,
+				Duration: d,
+				Error:    e.Error,
+			})
+		}
+	}
+
+	for _, req := range pending {
+		unanswered = append(unanswered, req)
+	}
+
+	for _, req := range unanswered {
+		if methods[req.Method] == nil {
+			methods[req.Method] = &Stat{Name: req.Method}
+		}
+
+		methods[req.Method].Unanswered++
+	}
+
+	for _, s := range queries {
+		s.Latency = latency(s.durations)
+		sum.Queries = append(sum.Queries, s)
+	}
+
+	for _, s := range methods {
+		s.Latency = latency(s.durations)
+		sum.Methods = append(sum.Methods, s)
+	}
+
+	sort.Slice(sum.Queries, func(i, j int) bool { return sum.Queries[i].Name < sum.Queries[j].Name })
+	sort.Slice(sum.Methods, func(i, j int) bool { return sum.Methods[i].Name < sum.Methods[j].Name })
+
+	sort.SliceStable(sum.SlowestQueries, func(i, j int) bool {
+		return sum.SlowestQueries[i].Duration > sum.SlowestQueries[j].Duration
+	})
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].Duration > requests[j].Duration
+	})
+
+	if len(sum.SlowestQueries) > n {
+		sum.SlowestQueries = sum.SlowestQueries[:n]
+	}
+
+	if len(requests) > n {
+		requests = requests[:n]
+	}
+
+	sum.SlowestRequests = requests
+
+	return sum
+}
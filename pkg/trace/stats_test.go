@@ -0,0 +1,154 @@
+package trace
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	ms := func(n ...int) []time.Duration {
+		var d []time.Duration
+		for _, i := range n {
+			d = append(d, time.Duration(i)*time.Millisecond)
+		}
+		return d
+	}
+
+	var twenty []int
+	for i := 20; i > 0; i-- {
+		twenty = append(twenty, i)
+	}
+
+	tests := []struct {
+		name string
+		in   []time.Duration
+		want Latency
+	}{
+		{"empty", nil, Latency{}},
+		{"one", ms(7), Latency{P50: ms(7)[0], P95: ms(7)[0], Max: ms(7)[0]}},
+		{"two", ms(9, 1), Latency{P50: ms(1)[0], P95: ms(9)[0], Max: ms(9)[0]}},
+		{"three", ms(3, 1, 2), Latency{P50: ms(2)[0], P95: ms(3)[0], Max: ms(3)[0]}},
+		{"twenty", ms(twenty...), Latency{P50: ms(10)[0], P95: ms(19)[0], Max: ms(20)[0]}},
+	}
+
+	for _, tt := range tests {
+		if got := latency(tt.in); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	lines := []string{
+		`{"kind":"request","server":"clangd","dir":"send","id":"0","method":"initialize","time":"%s"}`,
+		`{"kind":"response","server":"clangd","dir":"recv","id":"0","time":"%s"}`,
+		`{"kind":"notification","server":"clangd","dir":"send","method":"initialized","time":"%s"}`,
+		`{"kind":"request","server":"clangd","dir":"send","id":"1","method":"textDocument/references","time":"%s"}`,
+		`{"kind":"response","server":"clangd","dir":"recv","id":"1","duration":30000000,"time":"%s"}`,
+		`{"kind":"query","search":0,"pattern":"main.c:1:1","results":3,"duration":35000000,"time":"%s"}`,
+		`{"kind":"request","server":"clangd","dir":"send","id":"2","method":"textDocument/definition","time":"%s"}`,
+		`{"kind":"query","search":1,"pattern":"main.c:1:1","duration":50000000,"error":{"message":"stopped server"},"time":"%s"}`,
+
+		// The server is restarted, and its request IDs start over.
+		`{"kind":"request","server":"clangd","dir":"send","id":"0","method":"initialize","time":"%s"}`,
+		`{"kind":"response","server":"clangd","dir":"recv","id":"0","time":"%s"}`,
+		`{"kind":"request","server":"clangd","dir":"send","id":"1","method":"textDocument/definition","time":"%s"}`,
+		`{"kind":"request","server":"clangd","dir":"send","id":"2","method":"textDocument/references","time":"%s"}`,
+		`{"kind":"response","server":"clangd","dir":"recv","id":"2","error":{"code":-32603,"message":"failed"},"time":"%s"}`,
+		`{"kind":"response","server":"clangd","dir":"recv","id":"1","time":"%s"}`,
+
+		// A request from the server isn't ours to answer.
+		`{"kind":"request","server":"clangd","dir":"recv","id":"7","method":"workspace/configuration","time":"%s"}`,
+		`{"kind":"log","message":"I[00:00:00] clangd","time":"%s"}`,
+	}
+
+	var trace strings.Builder
+
+	for i, l := range lines {
+		// Each event is 10ms after the one before.
+		fmt.Fprintf(&trace, l+"\n", start.Add(time.Duration(i)*10*time.Millisecond).Format(time.RFC3339Nano))
+	}
+
+	events, err := Read(strings.NewReader(trace.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := Summarize(events, 2, func(n int) string {
+		return []string{"symbol", "definition"}[n]
+	})
+
+	type stat struct {
+		Name       string
+		Count      int
+		Errors     map[int64]int
+		Unanswered int
+		Latency    Latency
+	}
+
+	flatten := func(stats []*Stat) []stat {
+		var s []stat
+		for _, st := range stats {
+			s = append(s, stat{st.Name, st.Count, st.Errors, st.Unanswered, st.Latency})
+		}
+		return s
+	}
+
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	lat := func(n int) Latency { return Latency{P50: ms(n), P95: ms(n), Max: ms(n)} }
+
+	wantQueries := []stat{
+		{"definition", 1, map[int64]int{0: 1}, 0, lat(50)},
+		{"symbol", 1, nil, 0, lat(35)},
+	}
+
+	// The definition request that was pending when the server
+	// restarted was never answered. Its ID was reused after the
+	// restart, which must not be matched to the old request.
+	wantMethods := []stat{
+		{"initialize", 2, nil, 0, lat(10)},
+		{"textDocument/definition", 1, nil, 1, lat(30)},
+		{"textDocument/references", 2, map[int64]int{-32603: 1}, 0, Latency{P50: ms(10), P95: ms(30), Max: ms(30)}},
+	}
+
+	if got := flatten(sum.Queries); !reflect.DeepEqual(got, wantQueries) {
+		t.Errorf("queries: got %+v, want %+v", got, wantQueries)
+	}
+
+	if got := flatten(sum.Methods); !reflect.DeepEqual(got, wantMethods) {
+		t.Errorf("methods: got %+v, want %+v", got, wantMethods)
+	}
+
+	wantNotifications := map[string]int{"initialized": 1}
+	if !reflect.DeepEqual(sum.Notifications, wantNotifications) {
+		t.Errorf("notifications: got %v, want %v", sum.Notifications, wantNotifications)
+	}
+
+	if len(sum.SlowestQueries) != 2 || sum.SlowestQueries[0].Duration != ms(50) {
+		t.Errorf("slowest queries: got %+v", sum.SlowestQueries)
+	}
+
+	if len(sum.SlowestRequests) != 2 ||
+		sum.SlowestRequests[0].Method != "textDocument/references" || sum.SlowestRequests[0].Duration != ms(30) ||
+		sum.SlowestRequests[1].Method != "textDocument/definition" || sum.SlowestRequests[1].Duration != ms(30) {
+		t.Errorf("slowest requests: got %+v", sum.SlowestRequests)
+	}
+}
+
+func TestSummarizeNegative(t *testing.T) {
+	events := []Event{
+		{Kind: KindQuery, Search: new(int), Duration: time.Millisecond},
+	}
+
+	sum := Summarize(events, -1, func(n int) string { return "symbol" })
+
+	if len(sum.SlowestQueries) != 0 || len(sum.SlowestRequests) != 0 {
+		t.Errorf("got %d slowest queries and %d requests, want none",
+			len(sum.SlowestQueries), len(sum.SlowestRequests))
+	}
+}
@@ -0,0 +1,245 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Kind is the type of a trace Event.
+type Kind string
+
+const (
+	// KindQuery records a cscope query and its results.
+	KindQuery Kind = "query"
+
+	// KindRequest records a JSON-RPC request.
+	KindRequest Kind = "request"
+
+	// KindResponse records a JSON-RPC response.
+	KindResponse Kind = "response"
+
+	// KindNotification records a JSON-RPC notification.
+	KindNotification Kind = "notification"
+
+	// KindLog records a line of diagnostic output.
+	KindLog Kind = "log"
+)
+
+// Direction is the direction of a JSON-RPC message.
+type Direction string
+
+const (
+	// Send is a message sent to the language server.
+	Send Direction = "send"
+
+	// Recv is a message received from the language server.
+	Recv Direction = "recv"
+)
+
+// Error describes a failed query or JSON-RPC request.
+type Error struct {
+	Code    int64  `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Event is a single trace record. Each Event is written as a line
+// of JSON.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+
+	// Server names the language server for JSON-RPC events.
+	Server string `json:"server,omitempty"`
+
+	// Dir, ID, Method and Size describe a JSON-RPC message. Size
+	// is the length of the JSON encoding of the message.
+	Dir    Direction `json:"dir,omitempty"`
+	ID     string    `json:"id,omitempty"`
+	Method string    `json:"method,omitempty"`
+	Size   int       `json:"size,omitempty"`
+
+	// Search, Pattern and Results describe a cscope query.
+	Search  *int   `json:"search,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Results *int   `json:"results,omitempty"`
+
+	// Duration is the query or request latency in nanoseconds, for
+	// query and response events.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Error is set if the query or request failed.
+	Error *Error `json:"error,omitempty"`
+
+	// Message is the text of a log event.
+	Message string `json:"message,omitempty"`
+}
+
+// Writer writes trace events as JSON lines. It is safe for concurrent
+// use.
+type Writer struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewWriter returns a Writer that writes events to out.
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{
+		enc: json.NewEncoder(out),
+	}
+}
+
+// Write writes a trace event, setting its timestamp if it is not
+// already set.
+func (w *Writer) Write(e *Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.enc.Encode(e)
+}
+
+// Query records a cscope query that produced the given number of
+// results, or failed with err.
+func (w *Writer) Query(search int, pattern string, results int, d time.Duration, err error) error {
+	e := Event{
+		Kind:     KindQuery,
+		Search:   &search,
+		Pattern:  pattern,
+		Duration: d,
+	}
+
+	if err != nil {
+		e.Error = &Error{Message: err.Error()}
+	} else {
+		e.Results = &results
+	}
+
+	return w.Write(&e)
+}
+
+// Log records a line of diagnostic output.
+func (w *Writer) Log(msg string) error {
+	return w.Write(&Event{
+		Kind:    KindLog,
+		Message: msg,
+	})
+}
+
+// Server returns a ServerTracer that records the JSON-RPC messages
+// exchanged with the named language server.
+func (w *Writer) Server(name string) *ServerTracer {
+	return &ServerTracer{
+		w:       w,
+		name:    name,
+		pending: map[pendingKey]pendingRequest{},
+	}
+}
+
+type pendingKey struct {
+	dir Direction
+	id  jsonrpc2.ID
+}
+
+type pendingRequest struct {
+	start  time.Time
+	method string
+}
+
+// ServerTracer records JSON-RPC messages and measures the latency of
+// each request. Its Send and Recv methods match the message hooks
+// of jsonrpc2.Conn.
+type ServerTracer struct {
+	w    *Writer
+	name string
+
+	lock    sync.Mutex
+	pending map[pendingKey]pendingRequest
+}
+
+// Send records a message sent to the server.
+func (t *ServerTracer) Send(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	t.record(Send, req, resp)
+}
+
+// Recv records a message received from the server.
+func (t *ServerTracer) Recv(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	t.record(Recv, req, resp)
+}
+
+func (t *ServerTracer) record(dir Direction, req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	now := time.Now()
+
+	e := Event{
+		Time:   now,
+		Server: t.name,
+		Dir:    dir,
+	}
+
+	switch {
+	case resp != nil:
+		e.Kind = KindResponse
+		e.ID = resp.ID.String()
+
+		if resp.Error != nil {
+			e.Error = &Error{
+				Code:    resp.Error.Code,
+				Message: resp.Error.Message,
+			}
+		}
+
+		// A response answers a request that went the
+		// other way.
+		key := pendingKey{dir: Send, id: resp.ID}
+		if dir == Send {
+			key.dir = Recv
+		}
+
+		t.lock.Lock()
+		if p, ok := t.pending[key]; ok {
+			e.Duration = now.Sub(p.start)
+			e.Method = p.method
+			delete(t.pending, key)
+		}
+		t.lock.Unlock()
+
+		if data, err := json.Marshal(resp); err == nil {
+			e.Size = len(data)
+		}
+
+	case req != nil && req.Notif:
+		e.Kind = KindNotification
+		e.Method = req.Method
+
+		if data, err := json.Marshal(req); err == nil {
+			e.Size = len(data)
+		}
+
+	case req != nil:
+		e.Kind = KindRequest
+		e.ID = req.ID.String()
+		e.Method = req.Method
+
+		t.lock.Lock()
+		t.pending[pendingKey{dir: dir, id: req.ID}] = pendingRequest{
+			start:  now,
+			method: req.Method,
+		}
+		t.lock.Unlock()
+
+		if data, err := json.Marshal(req); err == nil {
+			e.Size = len(data)
+		}
+
+	default:
+		return
+	}
+
+	t.w.Write(&e)
+}
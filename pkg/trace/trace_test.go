@@ -0,0 +1,69 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestServerTracer(t *testing.T) {
+	var buf bytes.Buffer
+
+	tracer := NewWriter(&buf).Server("clangd")
+
+	req := &jsonrpc2.Request{
+		Method: "textDocument/references",
+		ID:     jsonrpc2.ID{Num: 7},
+	}
+
+	// A server request with the same id is tracked separately.
+	serverReq := &jsonrpc2.Request{
+		Method: "window/workDoneProgress/create",
+		ID:     jsonrpc2.ID{Num: 7},
+	}
+
+	tracer.Send(req, nil)
+	tracer.Recv(serverReq, nil)
+	time.Sleep(10 * time.Millisecond)
+	tracer.Send(nil, &jsonrpc2.Response{ID: jsonrpc2.ID{Num: 7}})
+	tracer.Recv(nil, &jsonrpc2.Response{ID: jsonrpc2.ID{Num: 7}})
+
+	var events []Event
+
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, e)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+
+	for _, e := range events {
+		if e.Server != "clangd" || e.ID != "7" {
+			t.Errorf("got server %q id %q, want clangd 7", e.Server, e.ID)
+		}
+	}
+
+	// The response we send answers the server's request.
+	if e := events[2]; e.Kind != KindResponse || e.Dir != Send || e.Method != serverReq.Method {
+		t.Errorf("got %s %s %q, want the response to %q", e.Dir, e.Kind, e.Method, serverReq.Method)
+	}
+
+	e := events[3]
+	if e.Kind != KindResponse || e.Dir != Recv || e.Method != req.Method {
+		t.Errorf("got %s %s %q, want the response to %q", e.Dir, e.Kind, e.Method, req.Method)
+	}
+
+	if e.Duration < 10*time.Millisecond {
+		t.Errorf("got duration %s, want at least 10ms", e.Duration)
+	}
+}
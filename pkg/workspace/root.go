@@ -0,0 +1,118 @@
+// Package workspace finds the root of the source tree that contains
+// a directory.
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jpeach/cscope-lsp/pkg/compdb"
+	"github.com/jpeach/cscope-lsp/pkg/config"
+)
+
+// Root is the root of a source tree.
+type Root struct {
+	// Dir is the absolute path of the root directory.
+	Dir string
+
+	// CompilationDatabase is the directory containing the
+	// compile_commands.json for the tree, or empty if there
+	// is none.
+	CompilationDatabase string
+
+	// Marker is the name of the file that marks Dir as a root,
+	// or empty if Dir was not found by its markers.
+	Marker string
+}
+
+// compdbDirs are the directories, relative to a candidate root, that
+// are searched for a compilation database. CMake users conventionally
+// generate it in "build".
+var compdbDirs = []string{".", "build"}
+
+// markers are files whose presence marks a root directory.
+var markers = []string{
+	"compile_flags.txt",
+	".git",
+	config.FileName,
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// findCompilationDatabase returns the directory that holds the
+// compilation database for the root dir, or an empty string.
+func findCompilationDatabase(dir string) string {
+	for _, sub := range compdbDirs {
+		db := filepath.Join(dir, sub)
+		if exists(filepath.Join(db, compdb.FileName)) {
+			return filepath.Clean(db)
+		}
+	}
+
+	return ""
+}
+
+// OpenRoot returns the Root for dir, which is a root whether or not
+// it has any root markers.
+func OpenRoot(dir string) (*Root, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	return &Root{
+		Dir:                 dir,
+		CompilationDatabase: findCompilationDatabase(dir),
+	}, nil
+}
+
+// FindRoot walks upward from dir looking for the nearest directory
+// that has a compilation database (either in the directory itself or
+// in its "build" subdirectory), a compile_flags.txt file, a .git
+// directory or a configuration file. If there is none, dir itself is
+// the root.
+func FindRoot(dir string) (*Root, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for d := dir; ; d = filepath.Dir(d) {
+		if db := findCompilationDatabase(d); db != "" {
+			return &Root{Dir: d, CompilationDatabase: db, Marker: compdb.FileName}, nil
+		}
+
+		for _, m := range markers {
+			if exists(filepath.Join(d, m)) {
+				return &Root{Dir: d, Marker: m}, nil
+			}
+		}
+
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+
+	return &Root{Dir: dir}, nil
+}
+
+// Contains returns true if path is inside the root directory.
+func (r *Root) Contains(path string) bool {
+	rel, err := filepath.Rel(r.Dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !startsWithDotDot(rel)
+}
+
+func startsWithDotDot(rel string) bool {
+	return len(rel) >= 3 && rel[:3] == ".."+string(filepath.Separator)
+}
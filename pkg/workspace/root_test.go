@@ -0,0 +1,184 @@
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/compdb"
+	"github.com/jpeach/cscope-lsp/pkg/config"
+)
+
+// makeTree creates the given files, and the directories that hold
+// them, under a new temporary directory. A name ending in "/" is
+// created as a directory.
+func makeTree(t *testing.T, names ...string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// Resolve symlinks (e.g. in $TMPDIR) so that paths compare
+	// equal to the ones FindRoot returns.
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestFindRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		start  string
+		dir    string
+		compdb string
+	}{{
+		name:   "compilation database in the root",
+		files:  []string{compdb.FileName, "src/lib/"},
+		start:  "src/lib",
+		dir:    ".",
+		compdb: ".",
+	}, {
+		name:   "compilation database in build",
+		files:  []string{"build/" + compdb.FileName, "src/"},
+		start:  "src",
+		dir:    ".",
+		compdb: "build",
+	}, {
+		name:  "git repository",
+		files: []string{".git/", "src/"},
+		start: "src",
+		dir:   ".",
+	}, {
+		name:  "compile_flags.txt",
+		files: []string{"compile_flags.txt", "src/"},
+		start: "src",
+		dir:   ".",
+	}, {
+		name:  "configuration file",
+		files: []string{config.FileName, "src/"},
+		start: "src",
+		dir:   ".",
+	}, {
+		// A compilation database and a marker in the same
+		// directory are the same root.
+		name:   "compilation database beside a marker",
+		files:  []string{".git/", "build/" + compdb.FileName, "src/"},
+		start:  "src",
+		dir:    ".",
+		compdb: "build",
+	}, {
+		// The nearest directory wins, even over a compilation
+		// database further up.
+		name:  "nearer marker",
+		files: []string{compdb.FileName, "vendor/zlib/.git/", "vendor/zlib/src/"},
+		start: "vendor/zlib/src",
+		dir:   "vendor/zlib",
+	}, {
+		name:   "nearer compilation database",
+		files:  []string{".git/", "sub/build/" + compdb.FileName, "sub/src/"},
+		start:  "sub/src",
+		dir:    "sub",
+		compdb: "sub/build",
+	}}
+
+	for _, tt := range tests {
+		tree := makeTree(t, tt.files...)
+
+		root, err := FindRoot(filepath.Join(tree, tt.start))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if want := filepath.Join(tree, tt.dir); root.Dir != want {
+			t.Errorf("%s: got root %s, want %s", tt.name, root.Dir, want)
+		}
+
+		want := ""
+		if tt.compdb != "" {
+			want = filepath.Join(tree, tt.compdb)
+		}
+
+		if root.CompilationDatabase != want {
+			t.Errorf("%s: got compilation database %q, want %q",
+				tt.name, root.CompilationDatabase, want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	root := &Root{Dir: "/src/project"}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/src/project", true},
+		{"/src/project/main.c", true},
+		{"/src/project/..c", true},
+		{"/src/project2/main.c", false},
+		{"/src/main.c", false},
+		{"/usr/include/stdio.h", false},
+	}
+
+	for _, tt := range tests {
+		if got := root.Contains(tt.path); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenRoot(t *testing.T) {
+	tree := makeTree(t, ".git/", "proj/build/"+compdb.FileName, "other/")
+
+	// A directory is a root even if a parent has the markers.
+	root, err := OpenRoot(filepath.Join(tree, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root.Dir != filepath.Join(tree, "other") || root.CompilationDatabase != "" {
+		t.Errorf("got root %+v, want %s with no compilation database", root, filepath.Join(tree, "other"))
+	}
+
+	root, err = OpenRoot(filepath.Join(tree, "proj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(tree, "proj/build"); root.CompilationDatabase != want {
+		t.Errorf("got compilation database %q, want %q", root.CompilationDatabase, want)
+	}
+
+	if _, err := OpenRoot(filepath.Join(tree, "missing")); err == nil {
+		t.Error("opened a missing directory")
+	}
+}
@@ -0,0 +1,67 @@
+package workspace
+
+import (
+	"path/filepath"
+)
+
+// Workspace is an ordered set of roots. The first root is the primary
+// root, which contains the working directory.
+type Workspace struct {
+	Roots []*Root
+}
+
+// Find returns the innermost root that contains path, or nil.
+func (w *Workspace) Find(path string) *Root {
+	var best *Root
+
+	for _, r := range w.Roots {
+		if r.Contains(path) && (best == nil || len(r.Dir) > len(best.Dir)) {
+			best = r
+		}
+	}
+
+	return best
+}
+
+// Add adds a root to the workspace. It returns false if the workspace
+// already has a root with the same directory.
+func (w *Workspace) Add(root *Root) bool {
+	for _, r := range w.Roots {
+		if r.Dir == root.Dir {
+			return false
+		}
+	}
+
+	w.Roots = append(w.Roots, root)
+	return true
+}
+
+// Dirs returns the directories of all the roots.
+func (w *Workspace) Dirs() []string {
+	dirs := make([]string, 0, len(w.Roots))
+	for _, r := range w.Roots {
+		dirs = append(dirs, r.Dir)
+	}
+
+	return dirs
+}
+
+// CompilationDatabases returns the distinct compilation database
+// directories of all the roots.
+func (w *Workspace) CompilationDatabases() []string {
+	var dbs []string
+
+	seen := map[string]bool{}
+
+	for _, r := range w.Roots {
+		db := filepath.Clean(r.CompilationDatabase)
+		if r.CompilationDatabase == "" || seen[db] {
+			continue
+		}
+
+		seen[db] = true
+		dbs = append(dbs, db)
+	}
+
+	return dbs
+}
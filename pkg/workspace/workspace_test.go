@@ -0,0 +1,47 @@
+package workspace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	ws := &Workspace{}
+
+	for _, dir := range []string{"/src", "/src/third_party/zlib", "/lib"} {
+		if !ws.Add(&Root{Dir: dir}) {
+			t.Errorf("%s: not added", dir)
+		}
+	}
+
+	if ws.Add(&Root{Dir: "/src"}) {
+		t.Error("/src: added twice")
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/src/main.c", "/src"},
+		{"/src/third_party/zlib/inflate.c", "/src/third_party/zlib"},
+		{"/src/third_party/zlib", "/src/third_party/zlib"},
+		{"/src/third_party/zlib2/x.c", "/src"},
+		{"/lib/lib.c", "/lib"},
+		{"/usr/include/stdio.h", ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if root := ws.Find(tt.path); root != nil {
+			got = root.Dir
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got root %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	if got, want := ws.Dirs(), []string{"/src", "/src/third_party/zlib", "/lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got dirs %q, want %q", got, want)
+	}
+}
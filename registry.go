@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/ccls"
+	"github.com/jpeach/cscope-lsp/pkg/config"
+	"github.com/jpeach/cscope-lsp/pkg/cquery"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/replay"
+	"github.com/jpeach/cscope-lsp/pkg/trace"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+// serverConfig describes how to launch a language server.
+type serverConfig struct {
+	// Path to the server executable.
+	Path string
+
+	// Args are additional arguments passed to the server.
+	Args []string
+
+	// Env holds additional environment variables in "key=value" form.
+	Env []string
+
+	// Backend is the kind of server, which is guessed from the
+	// server path if it is empty.
+	Backend string
+
+	// InitializationOptions are merged over the default options
+	// for the backend.
+	InitializationOptions json.RawMessage
+
+	// Launcher is a command prefix used to start the server.
+	Launcher []string
+
+	// Paths maps host paths to the server's file system.
+	Paths lsp.PathMap
+}
+
+// name returns a name for the server, suitable for messages.
+func (c *serverConfig) name() string {
+	return filepath.Base(c.Path)
+}
+
+// backend returns the kind of server, e.g. "ccls" or "cquery".
+func (c *serverConfig) backend() string {
+	if c.Backend != "" {
+		return c.Backend
+	}
+
+	for _, b := range []string{"ccls", "cquery", "clangd"} {
+		if strings.Contains(c.name(), b) {
+			return b
+		}
+	}
+
+	return c.name()
+}
+
+// args returns the arguments to start the server with in the given
+// workspace.
+func (c *serverConfig) args(ws *workspace.Workspace) []string {
+	args := append([]string{}, c.Args...)
+
+	// clangd takes a single compilation database directory. If the
+	// roots have their own databases, leave clangd to find the
+	// database for each file by searching its parent directories.
+	if dbs := ws.CompilationDatabases(); c.backend() == "clangd" && len(dbs) == 1 {
+		args = append(args, "--compile-commands-dir="+c.Paths.ToServer(dbs[0]))
+	}
+
+	return args
+}
+
+// initializationOptions returns the initializationOptions to send
+// to the server. The default options depend on the backend. ccls and
+// cquery keep their caches in the primary root, and can only use one
+// compilation database, so they use the first one in the workspace.
+func (c *serverConfig) initializationOptions(ws *workspace.Workspace) (interface{}, error) {
+	var init interface{}
+
+	root := &workspace.Root{Dir: c.Paths.ToServer(ws.Roots[0].Dir)}
+
+	if dbs := ws.CompilationDatabases(); len(dbs) > 0 {
+		root.CompilationDatabase = c.Paths.ToServer(dbs[0])
+	}
+
+	switch c.backend() {
+	case "ccls":
+		init = ccls.InitializationOptions{
+			Cache: ccls.CacheOptions{
+				Directory:        path.Join(root.Dir, ".ccls"),
+				HierarchicalPath: true,
+				Format:           "binary",
+			},
+			CompilationDatabaseDirectory: root.CompilationDatabase,
+		}
+	case "cquery":
+		opts := cquery.InitializationOptions{
+			CacheDirectory: path.Join(root.Dir, ".cquery"),
+		}
+
+		if root.CompilationDatabase != "" {
+			opts.CompilationDatabaseDirectory = lsp.String(root.CompilationDatabase)
+		}
+
+		init = opts
+	}
+
+	return config.Merge(init, c.InitializationOptions)
+}
+
+// client is a running language server, together with the documents
+// that we have open in it.
+type client struct {
+	srv  *lsp.Server
+	docs *lsp.DocumentManager
+
+	// backend is the kind of server, e.g. "clangd" or "cquery".
+	backend string
+
+	// indexed is set once we have waited for the server to index
+	// the workspace.
+	indexed bool
+}
+
+// indexQuiet is how long a server has to report no progress before we
+// take its index to be complete. Servers begin indexing a little after
+// they start, or after the first document is opened, so the absence of
+// progress is not enough.
+const indexQuiet = time.Second
+
+// waitIndexed waits, at most timeout, for the server to finish the work
+// that it reports with progress notifications, such as indexing the
+// workspace. References and call hierarchies are incomplete until it
+// does. Only the first call waits, and a zero timeout doesn't wait.
+func (c *client) waitIndexed(timeout time.Duration) {
+	if c.indexed || timeout == 0 {
+		return
+	}
+
+	c.indexed = true
+
+	if !c.srv.WaitIdle(indexQuiet, timeout) {
+		fmt.Fprintf(os.Stderr, "%s: %s is still indexing, so results may be incomplete\n", PROGNAME, c.backend)
+	}
+}
+
+// registry maps language IDs to language servers, and starts each
+// server the first time a file in one of its languages is queried.
+// Languages that share a serverConfig share a single server.
+type registry struct {
+	// opts are options common to all the servers.
+	opts []lsp.ServerOption
+
+	// ws holds the workspace roots that the servers index.
+	ws *workspace.Workspace
+
+	// trace, if not nil, records the messages exchanged with
+	// each server.
+	trace *trace.Writer
+
+	// record, if not nil, records complete sessions with each
+	// server so that they can be replayed later.
+	record *replay.Recorder
+
+	// replay, if not nil, is a recorded session that answers
+	// requests in place of the real servers.
+	replay *replay.Recording
+
+	languages map[string]*serverConfig
+	clients   map[*serverConfig]*client
+}
+
+func newRegistry(ws *workspace.Workspace, opts []lsp.ServerOption) *registry {
+	return &registry{
+		opts:      opts,
+		ws:        ws,
+		languages: map[string]*serverConfig{},
+		clients:   map[*serverConfig]*client{},
+	}
+}
+
+// register uses the server described by cfg for each of the given
+// language IDs.
+func (r *registry) register(cfg *serverConfig, langs ...string) {
+	for _, l := range langs {
+		r.languages[l] = cfg
+	}
+}
+
+// configs returns each of the registered server configurations once.
+func (r *registry) configs() []*serverConfig {
+	var configs []*serverConfig
+
+	seen := map[*serverConfig]bool{}
+
+	for _, cfg := range r.languages {
+		if !seen[cfg] {
+			seen[cfg] = true
+			configs = append(configs, cfg)
+		}
+	}
+
+	return configs
+}
+
+// start launches and initializes the server described by cfg.
+func (r *registry) start(cfg *serverConfig) (*client, error) {
+	srv, err := lsp.NewServer()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := append([]lsp.ServerOption{}, r.opts...)
+	opts = append(opts,
+		lsp.OptPath(cfg.Path),
+		lsp.OptArgs(cfg.args(r.ws)),
+		lsp.OptEnv(cfg.Env),
+		lsp.OptLauncher(cfg.Launcher),
+		lsp.OptPathMap(cfg.Paths),
+	)
+
+	if r.trace != nil {
+		opts = append(opts, lsp.OptTrace(r.trace.Server(cfg.name())))
+	}
+
+	if r.record != nil {
+		opts = append(opts, lsp.OptTrace(r.record.Server(cfg.name())))
+	}
+
+	if r.replay != nil {
+		opts = append(opts, lsp.OptConn(func() (io.ReadWriteCloser, error) {
+			return r.replay.Dial(cfg.name()), nil
+		}))
+	}
+
+	if err = srv.Start(opts); err != nil {
+		return nil, fmt.Errorf("failed to start LSP server: %s", err)
+	}
+
+	init, err := cfg.initializationOptions(r.ws)
+	if err != nil {
+		srv.Stop()
+		return nil, fmt.Errorf("invalid initializationOptions: %s", err)
+	}
+
+	if err := lsp.Initialize(srv, r.ws.Dirs(), init); err != nil {
+		srv.Stop()
+		return nil, fmt.Errorf("LSP initialization failed: %s", err)
+	}
+
+	return &client{
+		srv:     srv,
+		docs:    lsp.NewDocumentManager(srv, *openDocs),
+		backend: cfg.backend(),
+	}, nil
+}
+
+// client returns the client for the given language ID, starting the
+// server if it is not already running.
+func (r *registry) client(lang string) (*client, error) {
+	cfg, ok := r.languages[lang]
+	if !ok {
+		if lang == "" {
+			return nil, fmt.Errorf("unknown language")
+		}
+
+		return nil, fmt.Errorf("no language server for '%s'", lang)
+	}
+
+	if c, ok := r.clients[cfg]; ok {
+		return c, nil
+	}
+
+	c, err := r.start(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %s", cfg.Path, err)
+	}
+
+	r.clients[cfg] = c
+	return c, nil
+}
+
+// include makes sure that path is in one of the workspace roots. If
+// it is not, and the root that contains it has a compilation database,
+// that root is added to the workspace, and the running servers are told
+// about the new root. Other files, such as system headers, or files in
+// a dotfiles repository or a vendored tree that only has a .git
+// directory, are left alone.
+func (r *registry) include(path string) error {
+	if r.ws.Find(path) != nil {
+		return nil
+	}
+
+	root, err := workspace.FindRoot(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	if root.CompilationDatabase == "" || !r.ws.Add(root) {
+		return nil
+	}
+
+	for _, c := range r.clients {
+		err := lsp.WorkspaceDidChangeWorkspaceFolders(c.srv, []string{root.Dir}, nil)
+		if err != nil && err != lsp.ErrStopped {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune forgets any servers that have stopped, so that they will be
+// restarted by the next query.
+func (r *registry) prune() {
+	for cfg, c := range r.clients {
+		if !c.srv.Running() {
+			delete(r.clients, cfg)
+		}
+	}
+}
+
+// stop stops all the running servers.
+func (r *registry) stop() {
+	for cfg, c := range r.clients {
+		c.srv.Stop()
+		delete(r.clients, cfg)
+	}
+}
+
+// parseServerFlag parses a "lang[,lang...]=command [args...]" server
+// specification.
+func parseServerFlag(spec string) ([]string, *serverConfig, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid server '%s'", spec)
+	}
+
+	langs := strings.Split(parts[0], ",")
+
+	for _, l := range langs {
+		if l == "" {
+			return nil, nil, fmt.Errorf("invalid server '%s'", spec)
+		}
+	}
+
+	cmd := strings.Fields(parts[1])
+	if len(cmd) == 0 {
+		return nil, nil, fmt.Errorf("invalid server '%s'", spec)
+	}
+
+	return langs, &serverConfig{Path: cmd[0], Args: cmd[1:]}, nil
+}
+
+// expandHome replaces a leading "~" in path with the home directory,
+// since the shell doesn't expand it in the middle of a flag value.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, path[1:]), nil
+}
+
+// parsePathMapFlag parses a "host=server" path mapping. A leading "~"
+// in the host path is the home directory.
+func parsePathMapFlag(spec string) (lsp.PathMapping, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return lsp.PathMapping{}, fmt.Errorf("invalid path mapping '%s'", spec)
+	}
+
+	host, err := expandHome(parts[0])
+	if err == nil {
+		host, err = filepath.Abs(host)
+	}
+
+	if err != nil {
+		return lsp.PathMapping{}, fmt.Errorf("invalid path mapping '%s': %s", spec, err)
+	}
+
+	return lsp.PathMapping{Host: host, Server: parts[1]}, nil
+}
+
+// newServerConfig converts a server from a configuration file.
+func newServerConfig(s *config.Server) *serverConfig {
+	env := make([]string, 0, len(s.Env))
+	for k, v := range s.Env {
+		env = append(env, k+"="+v)
+	}
+
+	sort.Strings(env)
+
+	paths := make(lsp.PathMap, 0, len(s.PathMappings))
+	for _, m := range s.PathMappings {
+		paths = append(paths, lsp.PathMapping{Host: m.Host, Server: m.Server})
+	}
+
+	return &serverConfig{
+		Path:                  s.Path,
+		Args:                  s.Args,
+		Env:                   env,
+		Backend:               s.Backend,
+		InitializationOptions: s.InitializationOptions,
+		Launcher:              s.Launcher,
+		Paths:                 paths,
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+func TestRegistryClient(t *testing.T) {
+	fake := lsptest.NewServer()
+
+	reg := fakeRegistry(t, fake)
+	reg.register(&serverConfig{Path: "gopls"}, "go")
+
+	client := func(path string) *client {
+		t.Helper()
+
+		c, err := reg.client(lsp.FileToLanguageID(path))
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		return c
+	}
+
+	// C and C++ share a server, which is started once.
+	c := client("main.c")
+
+	if client("util.h") != c || client("main.cc") != c {
+		t.Error("C and C++ files have different clients")
+	}
+
+	if client("main.go") == c {
+		t.Error("Go files have the C client")
+	}
+
+	if n := len(fake.ReceivedMethod("initialize")); n != 2 {
+		t.Errorf("got %d initialize requests, want 2", n)
+	}
+
+	for _, path := range []string{"main.py", "Makefile"} {
+		if _, err := reg.client(lsp.FileToLanguageID(path)); err == nil {
+			t.Errorf("%s: got a client, want an error", path)
+		}
+	}
+
+	// A stopped server is restarted by the next query.
+	c.srv.Stop()
+	reg.prune()
+
+	if client("main.c") == c {
+		t.Error("got the stopped client")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"proj/compile_commands.json",
+		"proj/main.c",
+		"lib/compile_commands.json",
+		"lib/lib.c",
+		"dotfiles/.git/HEAD",
+		"dotfiles/vimrc.c",
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte("[]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := workspace.OpenRoot(filepath.Join(dir, "proj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &workspace.Workspace{}
+	ws.Add(root)
+
+	reg := newRegistry(ws, nil)
+
+	for _, f := range []string{"proj/main.c", "lib/lib.c", "dotfiles/vimrc.c"} {
+		if err := reg.include(filepath.Join(dir, f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, r := range ws.Roots {
+		got = append(got, filepath.Base(r.Dir))
+	}
+
+	// Only roots that have a compilation database are added.
+	if len(got) != 2 || got[0] != "proj" || got[1] != "lib" {
+		t.Errorf("got roots %q, want [proj lib]", got)
+	}
+}
+
+func TestWaitIndexed(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.Definition(fakeLocation(t, "util.c", 2, 4, 7))
+
+	reg := fakeRegistry(t, fake)
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fake.Progress(1, lsp.WorkDoneProgress{Kind: "begin", Title: "indexing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The progress is handled before the response.
+	if _, err := lsp.TextDocumentDefinition(c.srv, "testdata/src/main.c", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	c.waitIndexed(timeout)
+
+	if d := time.Since(start); d < timeout {
+		t.Errorf("waited %s for a busy server, want %s", d, timeout)
+	}
+
+	// Only the first call waits.
+	start = time.Now()
+	c.waitIndexed(timeout)
+
+	if d := time.Since(start); d >= timeout {
+		t.Errorf("waited %s again", d)
+	}
+}
+
+func TestParsePathMapFlag(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	abs := func(path string) string {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	tests := []struct {
+		spec string
+		host string
+	}{
+		{"/work/proj=/src", "/work/proj"},
+		{"proj=/src", abs("proj")},
+		{"~/work/proj=/src", filepath.Join(home, "work/proj")},
+		{"~=/src", home},
+		{"~other/proj=/src", abs("~other/proj")},
+		{"/work/proj", ""},
+		{"=/src", ""},
+		{"/work/proj=", ""},
+	}
+
+	for _, tt := range tests {
+		m, err := parsePathMapFlag(tt.spec)
+
+		if tt.host == "" {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", tt.spec, m)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", tt.spec, err)
+			continue
+		}
+
+		if m.Host != tt.host || m.Server != "/src" {
+			t.Errorf("%q: got %+v, want host %q", tt.spec, m, tt.host)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+)
+
+// Result orderings, selected by the --order flag or the "order"
+// configuration field.
+const (
+	// orderCscope lists definitions first, then results in the
+	// queried file, then the other results by path and line.
+	orderCscope = "cscope"
+
+	// orderPath lists results by path and line.
+	orderPath = "path"
+
+	// orderServer keeps the order that the server returned.
+	orderServer = "server"
+)
+
+// checkOrder returns an error if order is not a valid result ordering.
+func checkOrder(order string) error {
+	switch order {
+	case orderCscope, orderPath, orderServer:
+		return nil
+	default:
+		return fmt.Errorf("invalid result order '%s'", order)
+	}
+}
+
+// resultKey identifies a result by its file and line.
+type resultKey struct {
+	File string
+	Line int
+}
+
+func keyOf(r cscope.Result) resultKey {
+	return resultKey{File: r.File, Line: r.Line}
+}
+
+// dedupResults removes results for the same file and line as an
+// earlier result. Combining the answers to several requests, or the
+// declaration and definition of a symbol, can return the same line
+// more than once.
+func dedupResults(results []cscope.Result) []cscope.Result {
+	seen := map[resultKey]bool{}
+	kept := results[:0]
+
+	for _, r := range results {
+		k := keyOf(r)
+		if seen[k] {
+			continue
+		}
+
+		seen[k] = true
+		kept = append(kept, r)
+	}
+
+	return kept
+}
+
+// sortResults sorts results into the given order. The origin is the
+// reported path of the queried file, and defs holds the results that
+// are definitions. The sort is stable, so that results which compare
+// equal stay in the server order.
+func sortResults(results []cscope.Result, order string, origin string, defs map[resultKey]bool) {
+	rank := func(r cscope.Result) int {
+		if order != orderCscope {
+			return 0
+		}
+
+		switch {
+		case defs[keyOf(r)]:
+			return 0
+		case r.File == origin:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	if order == orderServer {
+		return
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		return a.Line < b.Line
+	})
+}
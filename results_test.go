@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+)
+
+// resultLocations formats results as "file:line" for comparison.
+func resultLocations(results []cscope.Result) []string {
+	var s []string
+	for _, r := range results {
+		s = append(s, fmt.Sprintf("%s:%d", r.File, r.Line))
+	}
+	return s
+}
+
+func TestDedupResults(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []cscope.Result
+		want []cscope.Result
+	}{{
+		name: "empty",
+	}, {
+		name: "unique",
+		in: []cscope.Result{
+			{File: "a.c", Line: 1},
+			{File: "a.c", Line: 2},
+			{File: "b.c", Line: 1},
+		},
+		want: []cscope.Result{
+			{File: "a.c", Line: 1},
+			{File: "a.c", Line: 2},
+			{File: "b.c", Line: 1},
+		},
+	}, {
+		// The first result for a line is kept, whatever its
+		// symbol or text.
+		name: "duplicates",
+		in: []cscope.Result{
+			{File: "b.c", Line: 3, Symbol: "f"},
+			{File: "a.c", Line: 1},
+			{File: "b.c", Line: 3, Symbol: "-"},
+			{File: "a.c", Line: 1},
+		},
+		want: []cscope.Result{
+			{File: "b.c", Line: 3, Symbol: "f"},
+			{File: "a.c", Line: 1},
+		},
+	}}
+
+	for _, tt := range tests {
+		got := dedupResults(tt.in)
+
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	in := []cscope.Result{
+		{File: "util.h", Line: 4},
+		{File: "main.c", Line: 10},
+		{File: "util.c", Line: 3},
+		{File: "main.c", Line: 5},
+		{File: "b/x.c", Line: 1},
+	}
+
+	defs := map[resultKey]bool{{File: "util.c", Line: 3}: true}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{orderCscope, []string{"util.c:3", "main.c:5", "main.c:10", "b/x.c:1", "util.h:4"}},
+		{orderPath, []string{"b/x.c:1", "main.c:5", "main.c:10", "util.c:3", "util.h:4"}},
+		{orderServer, []string{"util.h:4", "main.c:10", "util.c:3", "main.c:5", "b/x.c:1"}},
+	}
+
+	for _, tt := range tests {
+		results := append([]cscope.Result{}, in...)
+		sortResults(results, tt.order, "main.c", defs)
+
+		if got := resultLocations(results); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	for _, order := range []string{orderCscope, orderPath, orderServer} {
+		if err := checkOrder(order); err != nil {
+			t.Errorf("%s: %s", order, err)
+		}
+	}
+
+	for _, order := range []string{"", "line"} {
+		if err := checkOrder(order); err == nil {
+			t.Errorf("%q: got no error", order)
+		}
+	}
+}
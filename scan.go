@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+// buildMarkers are files that mark a directory as a build tree, such
+// as a CMake or Ninja build directory.
+var buildMarkers = []string{
+	"CMakeCache.txt",
+	"build.ninja",
+}
+
+// isBuildTree returns true if dir is a build tree inside the root r,
+// which holds the root's compilation database or a build marker.
+func isBuildTree(r *workspace.Root, dir string) bool {
+	if r.CompilationDatabase != "" && filepath.Clean(r.CompilationDatabase) == dir && dir != r.Dir {
+		return true
+	}
+
+	for _, m := range buildMarkers {
+		if _, err := os.Stat(filepath.Join(dir, m)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walkWorkspace calls fn for each regular file in the workspace
+// roots. Hidden directories and build trees are skipped, as are
+// directories and files that the filter excludes, if filter is not
+// nil. Files are matched by the names that paths reports for them.
+func walkWorkspace(ws *workspace.Workspace, paths *pathMapper, filter *pathFilter, fn func(path string) error) error {
+	for _, r := range ws.Roots {
+		err := filepath.Walk(r.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Skip anything that we can't read.
+				return nil
+			}
+
+			if info.IsDir() {
+				if path == r.Dir {
+					return nil
+				}
+
+				if strings.HasPrefix(info.Name(), ".") || isBuildTree(r, path) || (filter != nil && filter.prune(path)) {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			if filter != nil && !filter.selected(path, paths.path(lsp.FileToURI(path))) {
+				return nil
+			}
+
+			return fn(path)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isBinary guesses whether data is from a binary file, in the same
+// way as grep, by looking for a NUL byte near the start.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// maxScanLine is the longest line that a scan matches. The rest of a
+// file with a longer line, which is usually generated, is skipped.
+const maxScanLine = 1024 * 1024
+
+// scanFile calls fn with each line of the text file at path, and its
+// 1-based line number. Binary files are skipped. The file is read a
+// line at a time, so that large files are not read into memory.
+func scanFile(path string, fn func(n int, line string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	if head, _ := r.Peek(8000); isBinary(head) {
+		return
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxScanLine)
+
+	for n := 1; scanner.Scan(); n++ {
+		fn(n, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+}
+
+// scan answers the text, egrep and file queries, which need no
+// language server, by scanning the files in the workspace.
+func scan(ws *workspace.Workspace, paths *pathMapper, filter *pathFilter, q *cscope.Query) ([]cscope.Result, error) {
+	var results []cscope.Result
+
+	switch q.Search {
+	case cscope.FindFile:
+		re, err := regexp.Compile(q.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		err = walkWorkspace(ws, paths, filter, func(path string) error {
+			name := paths.path(lsp.FileToURI(path))
+
+			// Like cscope, report the file at its first line,
+			// since vim needs a line to jump to, with no symbol
+			// or text.
+			if re.MatchString(name) {
+				results = append(results, cscope.Result{
+					File:   name,
+					Line:   1,
+					Symbol: "<unknown>",
+					Text:   "<unknown>",
+				})
+			}
+
+			return nil
+		})
+
+		return results, err
+
+	case cscope.FindTextString, cscope.FindEgrepPattern:
+		expr := q.Pattern
+		if q.Search == cscope.FindTextString {
+			expr = regexp.QuoteMeta(expr)
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		err = walkWorkspace(ws, paths, filter, func(path string) error {
+			name := paths.path(lsp.FileToURI(path))
+
+			scanFile(path, func(n int, line string) {
+				if re.MatchString(line) {
+					results = append(results, cscope.Result{
+						File:   name,
+						Line:   n,
+						Symbol: "-",
+						Text:   line,
+					})
+				}
+			})
+
+			return nil
+		})
+
+		return results, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.c":                      "int main(void)\r\n{\r\n\treturn count;\r\n}\r\n",
+		"lib/count.c":                 "int count;\n",
+		"lib/count.o":                 "count\x00\x01",
+		".git/count":                  "count\n",
+		"build/compile_commands.json": "[]",
+		"build/gen/count.c":           "int count;\n",
+		"cmake-build/CMakeCache.txt":  "",
+		"cmake-build/count.c":         "int count;\n",
+	}
+
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := workspace.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &workspace.Workspace{}
+	ws.Add(root)
+
+	paths := &pathMapper{wd: dir, ws: ws}
+
+	tests := []struct {
+		search  cscope.SearchType
+		pattern string
+		want    []string
+	}{
+		{cscope.FindTextString, "count", []string{
+			"lib/count.c - 1 int count;",
+			"main.c - 3 \treturn count;",
+		}},
+		{cscope.FindEgrepPattern, "^int [a-z]+\\(", []string{
+			"main.c - 1 int main(void)",
+		}},
+		{cscope.FindFile, "count", []string{
+			"lib/count.c <unknown> 1 <unknown>",
+			"lib/count.o <unknown> 1 <unknown>",
+		}},
+	}
+
+	for _, tt := range tests {
+		results, err := scan(ws, paths, nil, &cscope.Query{Search: tt.search, Pattern: tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, r := range results {
+			got = append(got, r.String())
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: got %q, want %q", tt.search, tt.pattern, got, tt.want)
+		}
+	}
+
+	// An exclude pattern matches the name that is reported, which
+	// here is relative to the parent of the root.
+	parent := &pathMapper{wd: filepath.Dir(dir), ws: ws}
+
+	filter, err := newPathFilter(ws, nil, []string{filepath.Base(dir) + "/lib/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := scan(ws, parent, filter, &cscope.Query{Search: cscope.FindTextString, Pattern: "count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].File != filepath.Join(filepath.Base(dir), "main.c") {
+		t.Errorf("got %+v with the reported name excluded", results)
+	}
+}
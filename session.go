@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/config"
+	"github.com/jpeach/cscope-lsp/pkg/linecache"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/replay"
+	"github.com/jpeach/cscope-lsp/pkg/trace"
+	"github.com/jpeach/cscope-lsp/pkg/workspace"
+)
+
+// session holds the configuration, workspace and language servers that
+// answer queries. The cscope interface and the subcommands that need
+// language servers each start one session.
+type session struct {
+	cfg  *config.Config
+	ws   *workspace.Workspace
+	reg  *registry
+	opts *searchOptions
+
+	// tracer is nil unless the --trace flag is given.
+	tracer *trace.Writer
+
+	// files are closed when the session is closed.
+	files []*os.File
+
+	// stderr is the original stderr, if it has been redirected to
+	// logPipe to capture log messages in the trace, and logDone is
+	// closed once everything written to logPipe has been traced.
+	stderr  *os.File
+	logPipe *os.File
+	logDone chan struct{}
+}
+
+// logDrainTimeout limits how long closing a session waits for the log
+// messages of language servers. A server that leaves a child process
+// running can keep the log pipe open.
+const logDrainTimeout = time.Second
+
+// newSession loads the configuration file, opens the workspace and
+// registers the language servers, applying the session flags. Servers
+// are started on demand.
+func newSession() (_ *session, err error) {
+	sess := &session{}
+
+	// Close the trace and record files if we fail.
+	defer func() {
+		if err != nil {
+			for _, f := range sess.files {
+				f.Close()
+			}
+		}
+	}()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for ext, id := range cfg.Languages {
+		lsp.Languages.Set(ext, id)
+	}
+
+	for _, l := range *langFlag {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid language mapping '%s'", l)
+		}
+
+		lsp.Languages.Set(parts[0], parts[1])
+	}
+
+	if sessionFlags.Changed("root") {
+		cfg.Roots = *rootFlag
+	}
+
+	ws, err := openWorkspace(cfg.Roots)
+	if err != nil {
+		return nil, err
+	}
+
+	lsp.Languages.Header = headerLanguage(ws)
+
+	lspOpts := []lsp.ServerOption{}
+
+	if sessionFlags.Changed("timeout") {
+		cfg.Timeout = config.Duration(*timeout)
+	}
+
+	if cfg.Timeout > 0 {
+		lspOpts = append(lspOpts, lsp.OptTimeout(time.Duration(cfg.Timeout)))
+	}
+
+	if sessionFlags.Changed("order") || cfg.Order == "" {
+		cfg.Order = *orderFlag
+	}
+
+	if err := checkOrder(cfg.Order); err != nil {
+		return nil, err
+	}
+
+	if sessionFlags.Changed("call-depth") || cfg.CallDepth == 0 {
+		cfg.CallDepth = *callDepth
+	}
+
+	if cfg.CallDepth < 1 {
+		return nil, fmt.Errorf("invalid call depth %d", cfg.CallDepth)
+	}
+
+	if sessionFlags.Changed("include") {
+		cfg.Include = *includes
+	}
+
+	if sessionFlags.Changed("exclude") {
+		cfg.Exclude = *excludes
+	}
+
+	filter, err := newPathFilter(ws, cfg.Include, cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &searchOptions{
+		Order:       cfg.Order,
+		Filter:      filter,
+		FilterScans: *scanFilter,
+		Lines:       linecache.New(lineCacheSize),
+		CallDepth:   cfg.CallDepth,
+		Paths:       newPathMapper(ws),
+	}
+
+	if *watchDelay > 0 {
+		lspOpts = append(lspOpts, lsp.OptWatch(*watchDelay))
+	}
+
+	var tracer *trace.Writer
+
+	if *traceFile != "" {
+		traceFd, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		sess.files = append(sess.files, traceFd)
+
+		tracer = trace.NewWriter(traceFd)
+	}
+
+	reg := newRegistry(ws, lspOpts)
+
+	if *traceLsp {
+		reg.trace = tracer
+	}
+
+	if *recordFile != "" {
+		recordFd, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		sess.files = append(sess.files, recordFd)
+
+		reg.record = replay.NewRecorder(recordFd)
+	}
+
+	if *replayFile != "" {
+		replayFd, err := os.Open(*replayFile)
+		if err != nil {
+			return nil, err
+		}
+
+		reg.replay, err = replay.Load(replayFd)
+		replayFd.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %s", *replayFile, err)
+		}
+	}
+
+	cfamily := []string{"c", "cpp", "objective-c", "objective-cpp", "cuda"}
+
+	reg.register(&serverConfig{Path: *cqueryPath}, cfamily...)
+	reg.register(&serverConfig{Path: "gopls"}, "go")
+	reg.register(&serverConfig{Path: "rust-analyzer"}, "rust")
+	reg.register(&serverConfig{Path: "pyright-langserver", Args: []string{"--stdio"}}, "python")
+	reg.register(&serverConfig{Path: "typescript-language-server", Args: []string{"--stdio"}},
+		"javascript", "javascriptreact", "typescript", "typescriptreact")
+
+	for i := range cfg.Servers {
+		reg.register(newServerConfig(&cfg.Servers[i]), cfg.Servers[i].Languages...)
+	}
+
+	// Command line flags override the configuration file.
+	if sessionFlags.Changed("cquery") {
+		reg.register(&serverConfig{Path: *cqueryPath}, cfamily...)
+	}
+
+	for _, spec := range *serverFlag {
+		langs, cfg, err := parseServerFlag(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		reg.register(cfg, langs...)
+	}
+
+	// The path mapping flags apply to every server, and the
+	// launcher flag to every server that has no launcher.
+	var paths lsp.PathMap
+
+	for _, spec := range *pathMap {
+		m, err := parsePathMapFlag(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, m)
+	}
+
+	for _, cfg := range reg.configs() {
+		if len(cfg.Launcher) == 0 {
+			cfg.Launcher = *launchFlag
+		}
+
+		cfg.Paths = append(cfg.Paths, paths...)
+	}
+
+	if *debugLsp {
+		if cxx, ok := reg.languages["cpp"]; ok {
+			cxx.Args = append(cxx.Args, "--log-all-to-stderr")
+		}
+	}
+
+	// Capture our stderr, and the stderr of the language servers, as
+	// log events in the trace. This is the last step, so that nothing
+	// can fail once stderr is redirected.
+	if tracer != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+
+		sess.stderr = os.Stderr
+		sess.logPipe = w
+		sess.logDone = make(chan struct{})
+
+		go func() {
+			defer close(sess.logDone)
+			defer r.Close()
+
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				tracer.Log(scanner.Text())
+			}
+		}()
+
+		os.Stderr = w
+	}
+
+	sess.cfg = cfg
+	sess.ws = ws
+	sess.reg = reg
+	sess.opts = opts
+	sess.tracer = tracer
+
+	return sess, nil
+}
+
+// close stops the language servers and closes the trace and record
+// files. Log messages that are still in the log pipe are traced first.
+func (s *session) close() {
+	s.reg.stop()
+	s.opts.Lines.Close()
+
+	if s.logPipe != nil {
+		os.Stderr = s.stderr
+		s.logPipe.Close()
+
+		select {
+		case <-s.logDone:
+		case <-time.After(logDrainTimeout):
+		}
+	}
+
+	for _, f := range s.files {
+		f.Close()
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSessionError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cscope-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	*traceFile = filepath.Join(dir, "trace.jsonl")
+	*replayFile = filepath.Join(dir, "missing.jsonl")
+
+	defer func() {
+		*traceFile = ""
+		*replayFile = ""
+	}()
+
+	stderr := os.Stderr
+
+	if sess, err := newSession(); err == nil {
+		sess.close()
+		t.Fatal("started a session with a missing replay file")
+	}
+
+	// A failed session leaves stderr alone.
+	if os.Stderr != stderr {
+		os.Stderr = stderr
+		t.Error("stderr is still redirected")
+	}
+}
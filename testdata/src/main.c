@@ -0,0 +1,11 @@
+#include "util.h"
+
+static int twice(int x)
+{
+	return add(x, x);
+}
+
+int main(void)
+{
+	return add(1, twice(2));
+}
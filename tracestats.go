@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/trace"
+
+	"github.com/spf13/pflag"
+)
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
+
+func formatErrors(errs map[int64]int) string {
+	if len(errs) == 0 {
+		return "-"
+	}
+
+	codes := make([]int64, 0, len(errs))
+	for c := range errs {
+		codes = append(codes, c)
+	}
+
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	parts := make([]string, 0, len(codes))
+	for _, c := range codes {
+		parts = append(parts, fmt.Sprintf("%d:%d", c, errs[c]))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func printStats(out io.Writer, title string, stats []*trace.Stat) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "%s\tCOUNT\tP50\tP95\tMAX\tERRORS\tUNANSWERED\n", title)
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%d\n",
+			s.Name, s.Count,
+			formatDuration(s.Latency.P50),
+			formatDuration(s.Latency.P95),
+			formatDuration(s.Latency.Max),
+			formatErrors(s.Errors),
+			s.Unanswered)
+	}
+
+	w.Flush()
+}
+
+// traceStats implements the "trace-stats" subcommand, which
+// summarises the latency and errors recorded in a --trace file.
+func traceStats(args []string) error {
+	flags := pflag.NewFlagSet("trace-stats", pflag.ContinueOnError)
+	slowest := flags.IntP("slowest", "n", 10, "Number of slowest queries and requests to show")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s trace-stats [OPTION...] FILE\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case flags.NArg() == 0:
+		flags.Usage()
+		return fmt.Errorf("missing trace file")
+	case flags.NArg() > 1:
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	if *slowest < 0 {
+		return fmt.Errorf("invalid number of slowest queries %d", *slowest)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	events, err := trace.Read(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", flags.Arg(0), err)
+	}
+
+	sum := trace.Summarize(events, *slowest, func(n int) string {
+		return cscope.SearchType(n).String()
+	})
+
+	out := os.Stdout
+
+	printStats(out, "SEARCH", sum.Queries)
+	fmt.Fprintln(out)
+
+	printStats(out, "METHOD", sum.Methods)
+	fmt.Fprintln(out)
+
+	if len(sum.Notifications) > 0 {
+		names := make([]string, 0, len(sum.Notifications))
+		for n := range sum.Notifications {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "NOTIFICATION\tCOUNT\n")
+		for _, n := range names {
+			fmt.Fprintf(w, "%s\t%d\n", n, sum.Notifications[n])
+		}
+		w.Flush()
+		fmt.Fprintln(out)
+	}
+
+	if len(sum.SlowestQueries) > 0 {
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "SLOWEST QUERY\tSEARCH\tDURATION\tRESULT\n")
+		for _, e := range sum.SlowestQueries {
+			search := "-"
+			if e.Search != nil {
+				search = cscope.SearchType(*e.Search).String()
+			}
+
+			result := "-"
+			switch {
+			case e.Error != nil:
+				result = e.Error.Message
+			case e.Results != nil:
+				result = fmt.Sprintf("%d results", *e.Results)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				e.Pattern, search, formatDuration(e.Duration), result)
+		}
+		w.Flush()
+		fmt.Fprintln(out)
+	}
+
+	if len(sum.SlowestRequests) > 0 {
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "SLOWEST REQUEST\tSERVER\tID\tTIME\tDURATION\tERROR\n")
+		for _, r := range sum.SlowestRequests {
+			errMsg := "-"
+			if r.Error != nil {
+				errMsg = fmt.Sprintf("%d: %s", r.Error.Code, r.Error.Message)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Method, r.Server, r.ID,
+				r.Time.Format("15:04:05.000"),
+				formatDuration(r.Duration), errMsg)
+		}
+		w.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jpeach/cscope-lsp/pkg/cscope"
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+
+	"github.com/spf13/pflag"
+)
+
+// testPatterns match test files, whose symbols the "unused" command
+// skips unless it is given the --tests flag.
+var testPatterns = []string{
+	"*_test.*",
+	"*_unittest.*",
+	"test_*",
+	"*.test.*",
+	"*.spec.*",
+	"**/test/**",
+	"**/tests/**",
+}
+
+// entryPoints are the names of functions that are called without any
+// references in the program.
+var entryPoints = map[string]bool{
+	"main": true,
+	"init": true,
+}
+
+// Symbol visibilities, selected by the --visibility flag of the
+// "unused" command.
+const (
+	visibilityAll      = "all"
+	visibilityStatic   = "static"
+	visibilityExported = "exported"
+)
+
+var (
+	matchStatic = regexp.MustCompile(`\bstatic\b`)
+	matchPub    = regexp.MustCompile(`\bpub\b`)
+	matchExport = regexp.MustCompile(`\bexport\b`)
+)
+
+// isStatic guesses whether a symbol is private to its file or package,
+// so that nothing outside the workspace can use it. The declaration is
+// the text of the definition up to the name.
+func isStatic(lang string, name string, decl string) bool {
+	switch lang {
+	case "go":
+		r, _ := utf8.DecodeRuneInString(name)
+		return !unicode.IsUpper(r)
+	case "python":
+		return strings.HasPrefix(name, "_")
+	case "rust":
+		return !matchPub.MatchString(decl)
+	case "javascript", "javascriptreact", "typescript", "typescriptreact":
+		return !matchExport.MatchString(decl)
+	default:
+		return matchStatic.MatchString(decl)
+	}
+}
+
+// isCandidate returns true if sym is a function, or a variable or
+// constant that is not inside a function. Servers don't usually report
+// local variables, but some do.
+func isCandidate(sym *lsp.SymbolInformation, syms []lsp.SymbolInformation) bool {
+	if isFunctionSymbol(sym.Kind) {
+		return true
+	}
+
+	switch lsp.SymbolKind(sym.Kind) {
+	case lsp.SymbolKindVariable, lsp.SymbolKindConstant:
+	default:
+		return false
+	}
+
+	for i := range syms {
+		if isFunctionSymbol(syms[i].Kind) && syms[i].Location.Range.Contains(sym.Location.Range) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isUsed returns true if any of the references to the symbol defined
+// at rng in path is outside the definition. This means that a function
+// which only calls itself is unused.
+func isUsed(refs []lsp.Location, path string, rng lsp.Range) bool {
+	for _, ref := range refs {
+		p, err := lsp.URIToPath(ref.URI)
+		if err != nil || p != path || !rng.Contains(ref.Range) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDefinedAt returns true if any of the definitions is in the range
+// rng of path.
+func isDefinedAt(defs []lsp.Location, path string, rng lsp.Range) bool {
+	for _, def := range defs {
+		p, err := lsp.URIToPath(def.URI)
+		if err == nil && p == path && rng.Contains(def.Range) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unusedSymbols returns the symbols defined in the file at path that
+// have no references outside their own definitions.
+func unusedSymbols(c *client, paths *pathMapper, path string, visibility string) ([]cscope.Result, error) {
+	s := c.srv
+	lang := lsp.FileToLanguageID(path)
+
+	syms, err := lsp.TextDocumentDocumentSymbol(s, path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := c.docs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := s.PositionEncoding()
+
+	var results []cscope.Result
+
+	for i := range syms {
+		sym := &syms[i]
+		name := unqualifiedName(sym.Name)
+
+		if !isCandidate(sym, syms) || entryPoints[name] {
+			continue
+		}
+
+		line, col := namePosition(doc, sym, enc)
+
+		if visibility != visibilityAll {
+			var decl []string
+
+			for l := sym.Location.Range.Start.Line; l < line; l++ {
+				decl = append(decl, doc.Line(l))
+			}
+
+			if text := doc.Line(line); col <= len(text) {
+				decl = append(decl, text[:col])
+			}
+
+			if isStatic(lang, name, strings.Join(decl, "\n")) != (visibility == visibilityStatic) {
+				continue
+			}
+		}
+
+		col = lsp.ByteToCharacter(doc.Line(line), col, enc)
+
+		refs, err := lsp.TextDocumentReferences(s, path, line, col, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if isUsed(refs, path, sym.Location.Range) {
+			continue
+		}
+
+		// Some servers don't count the definition as a
+		// declaration, so a symbol that is declared in one file
+		// and defined in another is only reported where it is
+		// defined.
+		defs, err := lsp.TextDocumentDefinition(s, path, line, col)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(defs) > 0 && !isDefinedAt(defs, path, sym.Location.Range) {
+			continue
+		}
+
+		if name == "" || strings.ContainsAny(name, " \t") {
+			name = "-"
+		}
+
+		// NOTE: We convert LSP 0-based lines back to Vim 1-based lines.
+		results = append(results, cscope.Result{
+			File:   paths.path(lsp.FileToURI(path)),
+			Line:   line + 1,
+			Symbol: name,
+			Text:   "-",
+		})
+	}
+
+	return results, nil
+}
+
+// unused implements the "unused" subcommand, which reports the
+// functions and global variables in the workspace that nothing uses.
+func unused(args []string) error {
+	flags := pflag.NewFlagSet("unused", pflag.ContinueOnError)
+	tests := flags.Bool("tests", false, "Report symbols that are defined in test files")
+	visibility := flags.String("visibility", visibilityAll, "Report 'all' symbols, or only 'static' or 'exported' symbols")
+	indexTimeout := flags.Duration("index-timeout", defaultIndexTimeout, "How long to wait for the language servers to index the workspace (0 to not wait)")
+
+	flags.AddFlagSet(sessionFlags)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s unused [OPTION...]\n", PROGNAME)
+		fmt.Fprintf(os.Stderr, "\nReports the functions, methods and global variables in the workspace\n")
+		fmt.Fprintf(os.Stderr, "that have no references outside their own definitions.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	switch *visibility {
+	case visibilityAll, visibilityStatic, visibilityExported:
+	default:
+		return fmt.Errorf("invalid visibility '%s'", *visibility)
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.close()
+
+	testFilter, err := newPathFilter(sess.ws, nil, testPatterns)
+	if err != nil {
+		return err
+	}
+
+	paths := sess.opts.Paths
+
+	var results []cscope.Result
+
+	err = walkWorkspace(sess.ws, paths, sess.opts.Filter, func(path string) error {
+		if _, ok := sess.reg.languages[lsp.FileToLanguageID(path)]; !ok {
+			return nil
+		}
+
+		if !*tests && !testFilter.selected(path, paths.path(lsp.FileToURI(path))) {
+			return nil
+		}
+
+		c, _, err := openPosition(sess.reg, &queryPosition{File: path})
+		if err != nil {
+			return err
+		}
+
+		// A server that is still indexing hasn't found all the
+		// references, so symbols that are used would be reported.
+		c.waitIndexed(*indexTimeout)
+
+		r, err := unusedSymbols(c, paths, path, *visibility)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		results = append(results, r...)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sortResults(results, orderPath, "", nil)
+	resolveTextForResults(sess.opts.Lines, paths.wd, results)
+
+	for _, r := range results {
+		fmt.Println(r)
+	}
+
+	return nil
+}
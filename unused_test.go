@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpeach/cscope-lsp/pkg/lsp"
+	"github.com/jpeach/cscope-lsp/pkg/lsptest"
+)
+
+func TestIsStatic(t *testing.T) {
+	tests := []struct {
+		lang string
+		name string
+		decl string
+		want bool
+	}{
+		{"c", "twice", "static int twice", true},
+		{"c", "main", "int main", false},
+		{"cpp", "f", "template <typename T>\nstatic inline void f", true},
+		{"c", "statics", "int statics", false},
+		{"go", "count", "func count", true},
+		{"go", "Count", "func (c *C) Count", false},
+		{"go", "état", "var état", true},
+		{"python", "_helper", "def _helper", true},
+		{"python", "helper", "def helper", false},
+		{"rust", "f", "fn f", true},
+		{"rust", "f", "pub fn f", false},
+		{"rust", "f", "pub(crate) fn f", false},
+		{"typescript", "f", "function f", true},
+		{"javascript", "f", "export function f", false},
+		{"typescriptreact", "exported", "const exported", true},
+	}
+
+	for _, tt := range tests {
+		if got := isStatic(tt.lang, tt.name, tt.decl); got != tt.want {
+			t.Errorf("%s %q in %q: got %t, want %t", tt.lang, tt.name, tt.decl, got, tt.want)
+		}
+	}
+}
+
+func TestIsUsed(t *testing.T) {
+	ref := func(file string, line int) lsp.Location {
+		return lsp.Location{
+			URI: lsp.FileToURI(file),
+			Range: lsp.Range{
+				Start: lsp.Position{Line: line, Character: 8},
+				End:   lsp.Position{Line: line, Character: 11},
+			},
+		}
+	}
+
+	// The definition of add in util.c.
+	def := lsp.Range{
+		Start: lsp.Position{Line: 2, Character: 0},
+		End:   lsp.Position{Line: 5, Character: 1},
+	}
+
+	tests := []struct {
+		name string
+		refs []lsp.Location
+		want bool
+	}{
+		{"no references", nil, false},
+		{"recursive call", []lsp.Location{ref("/src/util.c", 4)}, false},
+		{"other file", []lsp.Location{ref("/src/main.c", 4)}, true},
+		{"same file", []lsp.Location{ref("/src/util.c", 8)}, true},
+		{"recursive and other", []lsp.Location{ref("/src/util.c", 4), ref("/src/main.c", 9)}, true},
+		{"not a file", []lsp.Location{{URI: "untitled:Untitled-1"}}, true},
+	}
+
+	for _, tt := range tests {
+		if got := isUsed(tt.refs, "/src/util.c", def); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnusedSymbols(t *testing.T) {
+	fake := lsptest.NewServer()
+	fake.DocumentSymbol(
+		fakeSymbol(t, "twice", "", lsp.SymbolKindFunction, "main.c", 2, 5),
+		fakeSymbol(t, "x", "", lsp.SymbolKindVariable, "main.c", 2, 2),
+		fakeSymbol(t, "main", "", lsp.SymbolKindFunction, "main.c", 7, 10),
+		fakeSymbol(t, "add", "", lsp.SymbolKindFunction, "util.c", 2, 5),
+	)
+
+	// refs holds the references to the symbol at each position,
+	// as "file:line:col".
+	refs := map[string][]lsp.Location{}
+
+	position := func(params json.RawMessage) (string, lsp.TextDocumentPositionParams) {
+		var p lsp.TextDocumentPositionParams
+		json.Unmarshal(params, &p)
+
+		path, _ := lsp.URIToPath(p.TextDocument.URI)
+		return fmt.Sprintf("%s:%d:%d", path, p.Position.Line, p.Position.Character), p
+	}
+
+	fake.Handle("textDocument/references", func(params json.RawMessage) (interface{}, error) {
+		key, _ := position(params)
+		return append([]lsp.Location{}, refs[key]...), nil
+	})
+
+	// Each symbol is defined where it is queried.
+	fake.Handle("textDocument/definition", func(params json.RawMessage) (interface{}, error) {
+		_, p := position(params)
+		return []lsp.Location{{URI: p.TextDocument.URI, Range: lsp.Range{Start: p.Position, End: p.Position}}}, nil
+	})
+
+	reg := fakeRegistry(t, fake)
+
+	c, err := reg.client("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, _ := os.Getwd()
+	paths := &pathMapper{wd: wd, ws: reg.ws}
+
+	mainPath := filepath.Join(wd, "testdata/src/main.c")
+	utilPath := filepath.Join(wd, "testdata/src/util.c")
+
+	tests := []struct {
+		name       string
+		path       string
+		visibility string
+		refs       map[string][]lsp.Location
+		want       []string
+	}{{
+		// main is an entry point, and x is a local variable.
+		name:       "unreferenced",
+		path:       mainPath,
+		visibility: visibilityAll,
+		want:       []string{"testdata/src/main.c twice 3 -"},
+	}, {
+		name:       "static",
+		path:       mainPath,
+		visibility: visibilityStatic,
+		want:       []string{"testdata/src/main.c twice 3 -"},
+	}, {
+		name:       "exported",
+		path:       mainPath,
+		visibility: visibilityExported,
+	}, {
+		name:       "referenced",
+		path:       mainPath,
+		visibility: visibilityAll,
+		refs: map[string][]lsp.Location{
+			mainPath + ":2:11": {fakeLocation(t, "main.c", 9, 15, 20)},
+		},
+	}, {
+		name:       "called",
+		path:       utilPath,
+		visibility: visibilityAll,
+		refs: map[string][]lsp.Location{
+			utilPath + ":2:4": {fakeLocation(t, "main.c", 4, 8, 11)},
+		},
+	}, {
+		// A function that only calls itself is unused.
+		name:       "recursive",
+		path:       utilPath,
+		visibility: visibilityAll,
+		refs: map[string][]lsp.Location{
+			utilPath + ":2:4": {fakeLocation(t, "util.c", 4, 8, 11)},
+		},
+		want: []string{"testdata/src/util.c add 3 -"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs = tt.refs
+
+			results, err := unusedSymbols(c, paths, tt.path, tt.visibility)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range results {
+				got = append(got, r.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}